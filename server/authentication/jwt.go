@@ -2,6 +2,7 @@ package authentication
 
 import (
 	"context"
+	"errors"
 	"fluffy-coto-tribble/server/services"
 	"fmt"
 	"log"
@@ -17,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -58,6 +60,16 @@ type UserClaims struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
 	Email     string `json:"email"`
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	TokenType string `json:"token_type"`
+	jwt.StandardClaims
+}
+
+// RefreshClaims identify the session a refresh token belongs to. The
+// standard Id claim (jti) is matched against the session's current token.
+type RefreshClaims struct {
+	SessionID string `json:"sid"`
 	TokenType string `json:"token_type"`
 	jwt.StandardClaims
 }
@@ -68,7 +80,8 @@ func NewAccessToken(claims UserClaims) (string, error) {
 	return accessToken.SignedString([]byte(AccessTokenSecret))
 }
 
-func NewRefreshToken(claims jwt.StandardClaims) (string, error) {
+func NewRefreshToken(claims RefreshClaims) (string, error) {
+	claims.TokenType = "refresh"
 	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return refreshToken.SignedString([]byte(RefreshTokenSecret))
 }
@@ -95,8 +108,8 @@ func ParseAccessToken(accessToken string) *UserClaims {
 	return claims
 }
 
-func ParseRefreshToken(refreshToken string) *jwt.StandardClaims {
-	parsedRefreshToken, err := jwt.ParseWithClaims(refreshToken, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+func ParseRefreshToken(refreshToken string) *RefreshClaims {
+	parsedRefreshToken, err := jwt.ParseWithClaims(refreshToken, &RefreshClaims{}, func(token *jwt.Token) (interface{}, error) {
 		// Ensure correct signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -108,7 +121,7 @@ func ParseRefreshToken(refreshToken string) *jwt.StandardClaims {
 		return nil
 	}
 
-	claims, ok := parsedRefreshToken.Claims.(*jwt.StandardClaims)
+	claims, ok := parsedRefreshToken.Claims.(*RefreshClaims)
 	if !ok {
 		fmt.Println("Failed to cast refresh token claims")
 		return nil
@@ -155,7 +168,9 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refreshToken" binding:"required"`
 }
 
-func RefreshTokenHandler(client *dynamodb.Client) gin.HandlerFunc {
+// RefreshTokenHandler rotates a session's refresh token. closeSessions is
+// called with a session revoked for token reuse, to drop its live sockets.
+func RefreshTokenHandler(client *dynamodb.Client, closeSessions func(sessionIDs ...string)) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req RefreshRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -164,17 +179,33 @@ func RefreshTokenHandler(client *dynamodb.Client) gin.HandlerFunc {
 		}
 
 		claims := ParseRefreshToken(req.RefreshToken)
-		if claims == nil {
+		if claims == nil || claims.SessionID == "" || claims.Id == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
 			return
 		}
 
-		userID := claims.Subject
+		session, err := services.GetSession(client, "sessions", claims.SessionID)
+		if err != nil || session.UserID != claims.Subject {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+			return
+		}
+		if session.Revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			return
+		}
+
+		// a refresh token that's no longer current has been used before,
+		// so whoever holds it may not be the user: kill the whole session
+		if session.TokenID != claims.Id {
+			revokeReusedSession(client, session.ID, closeSessions)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, session revoked"})
+			return
+		}
 
 		out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
 			TableName: aws.String("users"), // adjust table name
 			Key: map[string]types.AttributeValue{
-				"id": &types.AttributeValueMemberS{Value: userID},
+				"id": &types.AttributeValueMemberS{Value: session.UserID},
 			},
 		})
 		if err != nil || out.Item == nil {
@@ -188,26 +219,45 @@ func RefreshTokenHandler(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
-		newClaims := UserClaims{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     user.Email,
-			TokenType: "access",
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(AccessTokenTTL).Unix(),
-				IssuedAt:  time.Now().Unix(),
-				Subject:   user.ID,
-			},
+		now := time.Now()
+		next := services.Session{
+			ID:        session.ID,
+			TokenID:   uuid.NewString(),
+			UserAgent: c.Request.UserAgent(),
+			IP:        c.ClientIP(),
+			LastUsed:  now.Unix(),
+			ExpiresAt: now.Add(RefreshTokenTTL).Unix(),
+		}
+		err = services.RotateSession(client, "sessions", claims.Id, next)
+		if errors.Is(err, services.ErrSessionStale) {
+			// lost a race with another refresh using the same token
+			revokeReusedSession(client, session.ID, closeSessions)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, session revoked"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate session"})
+			return
 		}
 
-		accessToken, err := NewAccessToken(newClaims)
+		accessToken, refreshToken, err := signSessionTokens(user, session.ID, next.TokenID, next.ExpiresAt)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"accessToken": accessToken,
+			"accessToken":  accessToken,
+			"refreshToken": refreshToken,
 		})
 	}
 }
+
+func revokeReusedSession(client *dynamodb.Client, sessionID string, closeSessions func(sessionIDs ...string)) {
+	log.Printf("Refresh token reuse on session %s, revoking\n", sessionID)
+	if err := services.RevokeSession(client, "sessions", sessionID); err != nil {
+		log.Printf("Failed to revoke session %s: %v\n", sessionID, err)
+	}
+	// drop its sockets even if the revoke didn't stick, they're suspect either way
+	closeSessions(sessionID)
+}
@@ -0,0 +1,43 @@
+package authentication
+
+import (
+	"fluffy-coto-tribble/server/services"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EffectiveRole is the claims' role, treating tokens issued before roles
+// existed as plain users.
+func (claims *UserClaims) EffectiveRole() string {
+	if claims.Role == "" {
+		return services.RoleUser
+	}
+	return claims.Role
+}
+
+// HasRole reports whether the claims carry any of the given roles.
+func (claims *UserClaims) HasRole(roles ...string) bool {
+	return slices.Contains(roles, claims.EffectiveRole())
+}
+
+// CanActFor reports whether the claims may change resources owned by
+// userID: their own, or anyone's for an admin.
+func (claims *UserClaims) CanActFor(userID string) bool {
+	return claims.ID == userID || claims.HasRole(services.RoleAdmin)
+}
+
+// RequireRole only lets requests through whose access token carries one of
+// the given roles. It must run after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := c.MustGet("claims").(*UserClaims)
+		if claims == nil || !claims.HasRole(roles...) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,74 @@
+package authentication
+
+import (
+	"fluffy-coto-tribble/server/services"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+// StartSession records a new session for the user on the device making
+// the request and returns its first access and refresh tokens. The device
+// is named by the X-Device-Name header, falling back to its user agent.
+func StartSession(client *dynamodb.Client, c *gin.Context, user services.User) (accessToken, refreshToken string, err error) {
+	now := time.Now()
+	session := services.Session{
+		ID:         uuid.NewString(),
+		UserID:     user.ID,
+		TokenID:    uuid.NewString(),
+		DeviceName: c.GetHeader("X-Device-Name"),
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		CreatedAt:  now.Unix(),
+		LastUsed:   now.Unix(),
+		ExpiresAt:  now.Add(RefreshTokenTTL).Unix(),
+	}
+	if session.DeviceName == "" {
+		session.DeviceName = session.UserAgent
+	}
+
+	if err := services.CreateSession(client, "sessions", session); err != nil {
+		return "", "", err
+	}
+
+	return signSessionTokens(user, session.ID, session.TokenID, session.ExpiresAt)
+}
+
+func signSessionTokens(user services.User, sessionID, tokenID string, refreshExpiresAt int64) (string, string, error) {
+	now := time.Now()
+
+	accessToken, err := NewAccessToken(UserClaims{
+		ID:        user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Role:      user.Role,
+		SessionID: sessionID,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
+			IssuedAt:  now.Unix(),
+			Subject:   user.ID,
+		},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create access token: %w", err)
+	}
+
+	refreshToken, err := NewRefreshToken(RefreshClaims{
+		SessionID: sessionID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			ExpiresAt: refreshExpiresAt,
+			IssuedAt:  now.Unix(),
+			Subject:   user.ID,
+		},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	return accessToken, refreshToken, nil
+}
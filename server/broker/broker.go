@@ -0,0 +1,10 @@
+package broker
+
+// Broker carries hub deliveries between server instances. Every frame
+// published by any instance is handed to the handler of every subscribed
+// instance, including the publisher itself.
+type Broker interface {
+	Publish(data []byte) error
+	Subscribe(handler func(data []byte)) error
+	Close() error
+}
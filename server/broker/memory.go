@@ -0,0 +1,69 @@
+package broker
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MemoryBroker is a single-process Broker. Frames are handed to subscribers
+// in publish order from one dispatch goroutine, so publishers never block on
+// a slow handler until the queue fills.
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers []func([]byte)
+	queue    chan []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	b := &MemoryBroker{
+		queue: make(chan []byte, 1024),
+		done:  make(chan struct{}),
+	}
+	go b.dispatch()
+	return b
+}
+
+func (b *MemoryBroker) Publish(data []byte) error {
+	select {
+	case <-b.done:
+		return fmt.Errorf("broker closed")
+	default:
+	}
+
+	select {
+	case b.queue <- data:
+		return nil
+	case <-b.done:
+		return fmt.Errorf("broker closed")
+	}
+}
+
+func (b *MemoryBroker) Subscribe(handler func([]byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.once.Do(func() { close(b.done) })
+	return nil
+}
+
+func (b *MemoryBroker) dispatch() {
+	for {
+		select {
+		case data := <-b.queue:
+			b.mu.RLock()
+			handlers := b.handlers
+			b.mu.RUnlock()
+			for _, h := range handlers {
+				h(data)
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
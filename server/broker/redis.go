@@ -0,0 +1,299 @@
+package broker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisBroker fans frames out over a Redis pub/sub channel. It speaks just
+// enough RESP to AUTH, SELECT, PUBLISH and SUBSCRIBE, so any server that
+// implements those commands can stand in for Redis.
+type RedisBroker struct {
+	addr     string
+	password string
+	db       int
+	channel  string
+
+	pubMu sync.Mutex
+	pub   *respConn
+
+	subMu sync.Mutex
+	sub   *respConn
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewRedisBroker connects to a redis://[:password@]host:port[/db] URL and
+// publishes on the given channel.
+func NewRedisBroker(rawURL, channel string) (*RedisBroker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported redis url scheme %q", u.Scheme)
+	}
+
+	b := &RedisBroker{
+		addr:    u.Host,
+		channel: channel,
+		done:    make(chan struct{}),
+	}
+	if u.Port() == "" {
+		b.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		b.password, _ = u.User.Password()
+	}
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		if b.db, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("invalid redis db %q", path)
+		}
+	}
+
+	// fail fast on a bad address or password
+	b.pub, err = dialRESP(b.addr, b.password, b.db)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *RedisBroker) Publish(data []byte) error {
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.pub == nil {
+			if b.pub, err = dialRESP(b.addr, b.password, b.db); err != nil {
+				continue
+			}
+		}
+		if _, err = b.pub.do("PUBLISH", b.channel, string(data)); err == nil {
+			return nil
+		}
+		b.pub.close()
+		b.pub = nil
+	}
+	return fmt.Errorf("failed to publish to redis: %w", err)
+}
+
+// Subscribe starts a goroutine that listens on the channel, reconnecting
+// with backoff until the broker is closed.
+func (b *RedisBroker) Subscribe(handler func([]byte)) error {
+	conn, err := b.subscribe()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		backoff := 100 * time.Millisecond
+		for {
+			err := b.listen(conn, handler)
+			select {
+			case <-b.done:
+				return
+			default:
+			}
+			log.Printf("Redis subscription lost: %v\n", err)
+
+			for {
+				time.Sleep(backoff)
+				if conn, err = b.subscribe(); err == nil {
+					backoff = 100 * time.Millisecond
+					break
+				}
+				select {
+				case <-b.done:
+					return
+				default:
+				}
+				if backoff < 10*time.Second {
+					backoff *= 2
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *RedisBroker) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+
+		b.subMu.Lock()
+		if b.sub != nil {
+			b.sub.close()
+		}
+		b.subMu.Unlock()
+
+		b.pubMu.Lock()
+		if b.pub != nil {
+			b.pub.close()
+			b.pub = nil
+		}
+		b.pubMu.Unlock()
+	})
+	return nil
+}
+
+func (b *RedisBroker) subscribe() (*respConn, error) {
+	conn, err := dialRESP(b.addr, b.password, b.db)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.do("SUBSCRIBE", b.channel); err != nil {
+		conn.close()
+		return nil, err
+	}
+
+	b.subMu.Lock()
+	b.sub = conn
+	b.subMu.Unlock()
+	return conn, nil
+}
+
+func (b *RedisBroker) listen(conn *respConn, handler func([]byte)) error {
+	defer conn.close()
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		if kind, _ := parts[0].(string); kind != "message" {
+			continue
+		}
+		if payload, ok := parts[2].(string); ok {
+			handler([]byte(payload))
+		}
+	}
+}
+
+// respConn is a bare RESP2 connection.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+func dialRESP(addr, password string, db int) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	rc := &respConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if password != "" {
+		if _, err := rc.do("AUTH", password); err != nil {
+			rc.close()
+			return nil, fmt.Errorf("redis auth failed: %w", err)
+		}
+	}
+	if db != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(db)); err != nil {
+			rc.close()
+			return nil, fmt.Errorf("redis select failed: %w", err)
+		}
+	}
+	return rc, nil
+}
+
+// do sends a command and returns its reply; error replies become errors.
+func (rc *respConn) do(args ...string) (interface{}, error) {
+	if err := rc.write(args...); err != nil {
+		return nil, err
+	}
+	reply, err := rc.read()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(respError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+func (rc *respConn) write(args ...string) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(a), a)
+	}
+	rc.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := io.WriteString(rc.conn, sb.String())
+	return err
+}
+
+// read parses one reply: simple strings and bulk strings become string,
+// integers int64, arrays []interface{}, nil bulk/array nil and errors respError.
+func (rc *respConn) read() (interface{}, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = rc.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected redis reply %q", line)
+	}
+}
+
+func (rc *respConn) close() {
+	rc.conn.Close()
+}
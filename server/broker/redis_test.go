@@ -0,0 +1,270 @@
+package broker
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process stand-in for Redis that speaks the RESP
+// subset RedisBroker uses: AUTH, SELECT, PUBLISH and SUBSCRIBE.
+type fakeRedis struct {
+	t        *testing.T
+	ln       net.Listener
+	password string
+
+	mu          sync.Mutex
+	conns       map[net.Conn]bool
+	subscribers map[string]map[net.Conn]bool // channel -> connections
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{
+		t:           t,
+		ln:          ln,
+		password:    password,
+		conns:       make(map[net.Conn]bool),
+		subscribers: make(map[string]map[net.Conn]bool),
+	}
+	go f.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		f.dropAll()
+	})
+	return f
+}
+
+func (f *fakeRedis) url() string {
+	if f.password != "" {
+		return fmt.Sprintf("redis://:%s@%s/2", f.password, f.ln.Addr())
+	}
+	return "redis://" + f.ln.Addr().String()
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns[conn] = true
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+// dropAll severs every open connection, as a Redis restart would.
+func (f *fakeRedis) dropAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = make(map[net.Conn]bool)
+	f.subscribers = make(map[string]map[net.Conn]bool)
+}
+
+func (f *fakeRedis) subscriberCount(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers[channel])
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		for _, subs := range f.subscribers {
+			delete(subs, conn)
+		}
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	rc := &respConn{conn: conn, r: bufio.NewReader(conn)}
+	authed := f.password == ""
+	for {
+		reply, err := rc.read()
+		if err != nil {
+			return
+		}
+		parts, _ := reply.([]interface{})
+		if len(parts) == 0 {
+			return
+		}
+		args := make([]string, len(parts))
+		for i, p := range parts {
+			args[i], _ = p.(string)
+		}
+
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			f.write(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		switch cmd {
+		case "AUTH":
+			if len(args) != 2 || args[1] != f.password {
+				f.write(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authed = true
+			f.write(conn, "+OK\r\n")
+		case "SELECT":
+			f.write(conn, "+OK\r\n")
+		case "SUBSCRIBE":
+			f.mu.Lock()
+			subs, ok := f.subscribers[args[1]]
+			if !ok {
+				subs = make(map[net.Conn]bool)
+				f.subscribers[args[1]] = subs
+			}
+			subs[conn] = true
+			f.mu.Unlock()
+			f.write(conn, fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n%s:1\r\n", bulk(args[1])))
+		case "PUBLISH":
+			f.mu.Lock()
+			var targets []net.Conn
+			for c := range f.subscribers[args[1]] {
+				targets = append(targets, c)
+			}
+			f.mu.Unlock()
+			for _, c := range targets {
+				f.write(c, fmt.Sprintf("*3\r\n$7\r\nmessage\r\n%s%s", bulk(args[1]), bulk(args[2])))
+			}
+			f.write(conn, fmt.Sprintf(":%d\r\n", len(targets)))
+		default:
+			f.write(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+func (f *fakeRedis) write(conn net.Conn, s string) {
+	conn.Write([]byte(s))
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// collect subscribes b and returns a channel of every frame it receives.
+func collect(t *testing.T, b *RedisBroker) <-chan string {
+	t.Helper()
+	got := make(chan string, 16)
+	if err := b.Subscribe(func(data []byte) { got <- string(data) }); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	return got
+}
+
+func expectFrame(t *testing.T, got <-chan string, want string) {
+	t.Helper()
+	select {
+	case frame := <-got:
+		if frame != want {
+			t.Fatalf("got frame %q, want %q", frame, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q", want)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisBrokerPublishSubscribe(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+
+	a, err := NewRedisBroker(f.url(), "hub")
+	if err != nil {
+		t.Fatalf("connect a: %v", err)
+	}
+	defer a.Close()
+	b, err := NewRedisBroker(f.url(), "hub")
+	if err != nil {
+		t.Fatalf("connect b: %v", err)
+	}
+	defer b.Close()
+
+	gotA, gotB := collect(t, a), collect(t, b)
+	waitFor(t, "both subscriptions", func() bool { return f.subscriberCount("hub") == 2 })
+
+	// binary-safe payloads survive the round trip, CRLF included
+	frame := "{\"data\":\"line one\r\nline two\"}"
+	if err := a.Publish([]byte(frame)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	expectFrame(t, gotA, frame)
+	expectFrame(t, gotB, frame)
+}
+
+func TestRedisBrokerRejectsBadPassword(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+
+	url := strings.Replace(f.url(), "secret", "wrong", 1)
+	if _, err := NewRedisBroker(url, "hub"); err == nil {
+		t.Fatal("expected an auth error")
+	}
+}
+
+func TestRedisBrokerReconnectsAfterDrop(t *testing.T) {
+	f := newFakeRedis(t, "")
+
+	b, err := NewRedisBroker(f.url(), "hub")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer b.Close()
+
+	got := collect(t, b)
+	waitFor(t, "subscription", func() bool { return f.subscriberCount("hub") == 1 })
+	if err := b.Publish([]byte("before")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	expectFrame(t, got, "before")
+
+	f.dropAll()
+	waitFor(t, "resubscription", func() bool { return f.subscriberCount("hub") == 1 })
+
+	// the publishing connection was dropped too and is redialled on demand
+	if err := b.Publish([]byte("after")); err != nil {
+		t.Fatalf("publish after drop: %v", err)
+	}
+	expectFrame(t, got, "after")
+}
+
+func TestRedisBrokerStopsAfterClose(t *testing.T) {
+	f := newFakeRedis(t, "")
+
+	b, err := NewRedisBroker(f.url(), "hub")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	collect(t, b)
+	waitFor(t, "subscription", func() bool { return f.subscriberCount("hub") == 1 })
+
+	b.Close()
+	waitFor(t, "unsubscription", func() bool { return f.subscriberCount("hub") == 0 })
+	time.Sleep(300 * time.Millisecond)
+	if n := f.subscriberCount("hub"); n != 0 {
+		t.Fatalf("closed broker resubscribed %d times", n)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fluffy-coto-tribble/server/services"
+	"net/http"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// ChatAccess loads the chat named by the given route param and only lets
+// its members through, plus callers holding one of the staff roles. The
+// chat is stored on the context for currentChat. It must run after
+// AuthMiddleware.
+func ChatAccess(client *dynamodb.Client, param string, staff ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chat, err := services.GetChatById(client, "chats", c.Param(param))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		claims := currentClaims(c)
+		if !slices.Contains(chat.Users, claims.ID) && (len(staff) == 0 || !claims.HasRole(staff...)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this chat"})
+			c.Abort()
+			return
+		}
+
+		c.Set("chat", chat)
+		c.Next()
+	}
+}
+
+// currentChat returns the chat ChatAccess stored on the request.
+func currentChat(c *gin.Context) *services.Chat {
+	chat, _ := c.MustGet("chat").(*services.Chat)
+	return chat
+}
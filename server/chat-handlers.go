@@ -1,9 +1,12 @@
 package server
 
 import (
+	"errors"
 	"fluffy-coto-tribble/server/services"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -19,12 +22,23 @@ func CreateChat(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
-		id := fmt.Sprintf("c_%s", ShortUUID())
+		if len(chat.Name) > chatFields["name"] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is too long"})
+			return
+		}
+
+		users := chat.Users
+		if claims := currentClaims(c); !slices.Contains(users, claims.ID) {
+			users = append(users, claims.ID)
+		}
+
+		id := NewID("c")
 		now := time.Now().Unix()
 
 		newChat := services.Chat{
 			ID:          id,
-			Users:       chat.Users,
+			Name:        chat.Name,
+			Users:       users,
 			Messages:    []string{}, // start empty
 			DateCreated: now,
 			DateUpdated: now,
@@ -34,6 +48,10 @@ func CreateChat(client *dynamodb.Client) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if err := services.AddChatMembers(client, "chat_members", id, users, now); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 
 		c.JSON(http.StatusCreated, gin.H{
 			"message": "Chat created successfully",
@@ -42,42 +60,144 @@ func CreateChat(client *dynamodb.Client) gin.HandlerFunc {
 	}
 }
 
+// ChatSummary is a chat as listed to one user, with their unread count.
+type ChatSummary struct {
+	services.Chat
+	LastActivity int64 `json:"lastActivity"`
+	Unread       int   `json:"unread"`
+}
+
+const (
+	defaultChatPageSize = 20
+	maxChatPageSize     = 100
+)
+
+// GetAllChats lists the caller's chats, most recently active first. Pass
+// the returned nextCursor back as ?cursor= to fetch the following page.
 func GetAllChats(client *dynamodb.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		chats, err := services.GetAllChats(client, "chats")
+		claims := currentClaims(c)
+
+		limit := defaultChatPageSize
+		if raw := c.Query("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+			limit = min(n, maxChatPageSize)
+		}
+
+		startKey, err := scopedCursor(c.Query("cursor"), map[string]string{"userId": claims.ID})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		members, lastKey, err := services.GetUserChatPage(client, "chat_members", claims.ID, int32(limit), startKey)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"chats": chats})
+		ids := make([]string, 0, len(members))
+		activity := make(map[string]int64, len(members))
+		for _, m := range members {
+			ids = append(ids, m.ChatID)
+			activity[m.ChatID] = m.LastActivity
+		}
+
+		chats, err := services.GetChatsByIds(client, "chats", ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		cursors, err := services.GetReadCursors(client, "chat_reads", claims.ID, ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		summaries := make([]ChatSummary, 0, len(chats))
+		for _, chat := range chats {
+			unread, err := services.CountUnread(client, "messages", chat.ID, claims.ID, cursors[chat.ID].LastReadAt)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			summaries = append(summaries, ChatSummary{Chat: chat, LastActivity: activity[chat.ID], Unread: unread})
+		}
+
+		nextCursor, err := services.EncodeCursor(lastKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"chats": summaries, "nextCursor": nextCursor})
 	}
 }
 
-func GetChatById(client *dynamodb.Client) gin.HandlerFunc {
+// AdminGetAllChats lists every chat on the platform.
+func AdminGetAllChats(client *dynamodb.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		chatID := c.Param("id")
-
-		chat, err := services.GetChatById(client, "chats", chatID)
+		chats, err := services.GetAllChats(client, "chats")
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"chat": chat})
+		c.JSON(http.StatusOK, gin.H{"chats": chats})
+	}
+}
+
+func GetChatById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"chat": currentChat(c)})
 	}
 }
 
+// chatFields are the chat attributes UpdateChat may change, with the
+// longest value each accepts. Members change through the member endpoints.
+var chatFields = map[string]int{
+	"name": 100,
+}
+
+// UpdateChat changes a chat's settings. Fields outside chatFields are
+// refused rather than ignored.
 func UpdateChat(client *dynamodb.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		chatID := c.Param("id")
-		var updates map[string]interface{}
+		chat := currentChat(c)
 
-		if err := c.ShouldBindJSON(&updates); err != nil {
+		var req map[string]interface{}
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 			return
 		}
+		if _, ok := req["users"]; ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Change members through /chats/:id/members"})
+			return
+		}
 
+		updates := map[string]interface{}{}
+		for field, value := range req {
+			maxLen, ok := chatFields[field]
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s can't be changed", field)})
+				return
+			}
+			str, ok := value.(string)
+			if !ok || len(str) > maxLen {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be a string of at most %d bytes", field, maxLen)})
+				return
+			}
+			updates[field] = str
+		}
+		if len(updates) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+			return
+		}
 		updates["dateUpdated"] = time.Now().Unix()
 
 		avUpdates, err := attributevalue.MarshalMap(updates)
@@ -86,7 +206,7 @@ func UpdateChat(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
-		if err := services.UpdateChat(client, "chats", chatID, avUpdates); err != nil {
+		if err := services.UpdateChat(client, "chats", chat.ID, avUpdates); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -95,11 +215,90 @@ func UpdateChat(client *dynamodb.Client) gin.HandlerFunc {
 	}
 }
 
+// AddChatMembers adds users to a chat. Any member may invite.
+func AddChatMembers(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Users []string `json:"users" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Users) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "users must be a list of user IDs"})
+			return
+		}
+
+		chat := currentChat(c)
+		users := slices.Clone(chat.Users)
+		for _, id := range req.Users {
+			if slices.Contains(users, id) {
+				continue
+			}
+			user, err := services.GetUserById(client, "users", id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if user == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User %s not found", id)})
+				return
+			}
+			users = append(users, id)
+		}
+
+		setChatUsers(c, client, chat, users)
+	}
+}
+
+// RemoveChatMember takes a user out of a chat. Members may leave; only
+// moderators and admins remove someone else.
+func RemoveChatMember(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chat := currentChat(c)
+		userID := c.Param("userId")
+
+		if !currentClaims(c).CanActFor(userID) && !currentClaims(c).HasRole(services.RoleModerator, services.RoleAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only moderators can remove other members"})
+			return
+		}
+		if !slices.Contains(chat.Users, userID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not a member of this chat"})
+			return
+		}
+
+		setChatUsers(c, client, chat, slices.DeleteFunc(slices.Clone(chat.Users), func(id string) bool { return id == userID }))
+	}
+}
+
+// setChatUsers saves a chat's new member list and brings chat_members in
+// line with it, then writes the response.
+func setChatUsers(c *gin.Context, client *dynamodb.Client, chat *services.Chat, users []string) {
+	now := time.Now().Unix()
+	err := services.SetChatUsers(client, "chats", chat.ID, users, chat.DateUpdated, now)
+	if errors.Is(err, services.ErrChatChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Chat was changed by another request, retry"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := syncChatMembers(client, chat.ID, chat.Users, users, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Chat members updated successfully", "users": users})
+}
+
 func DeleteChat(client *dynamodb.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		chatID := c.Param("id")
+		chat := currentChat(c)
 
-		if err := services.DeleteChat(client, "chats", chatID); err != nil {
+		if err := services.DeleteChat(client, "chats", chat.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := services.RemoveChatMembers(client, "chat_members", chat.ID, chat.Users); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -107,3 +306,86 @@ func DeleteChat(client *dynamodb.Client) gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"message": "Chat deleted successfully"})
 	}
 }
+
+// syncChatMembers brings the membership index in line with a chat's new
+// user list.
+func syncChatMembers(client *dynamodb.Client, chatID string, before, after []string, now int64) error {
+	var added, removed []string
+	for _, id := range after {
+		if !slices.Contains(before, id) {
+			added = append(added, id)
+		}
+	}
+	for _, id := range before {
+		if !slices.Contains(after, id) {
+			removed = append(removed, id)
+		}
+	}
+
+	if err := services.AddChatMembers(client, "chat_members", chatID, added, now); err != nil {
+		return err
+	}
+	return services.RemoveChatMembers(client, "chat_members", chatID, removed)
+}
+
+func MarkChatRead(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chat := currentChat(c)
+		claims := currentClaims(c)
+
+		var req struct {
+			MessageID string `json:"messageId"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+				return
+			}
+		}
+
+		cursor, err := markRead(client, hub, chat, claims.ID, req.MessageID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"read": cursor})
+	}
+}
+
+// markRead moves the user's read cursor up to messageID, or to now when
+// messageID is empty, and sends read.marked to the chat's participants. If
+// the user has already read further, their current cursor is returned as is.
+func markRead(client *dynamodb.Client, hub *Hub, chat *services.Chat, userID, messageID string) (services.ReadCursor, error) {
+	now := time.Now().Unix()
+	cursor := services.ReadCursor{
+		ChatID:     chat.ID,
+		UserID:     userID,
+		LastReadAt: now,
+		UpdatedAt:  now,
+	}
+
+	if messageID != "" {
+		msg, err := services.GetChatMessage(client, "messages", chat.ID, messageID)
+		if err != nil {
+			return services.ReadCursor{}, err
+		}
+		cursor.LastReadMessageID = msg.ID
+		cursor.LastReadAt = msg.Timestamp
+	}
+
+	moved, err := services.SetReadCursor(client, "chat_reads", cursor)
+	if err != nil {
+		return services.ReadCursor{}, err
+	}
+	if !moved {
+		current, err := services.GetReadCursor(client, "chat_reads", chat.ID, userID)
+		if err != nil {
+			return services.ReadCursor{}, err
+		}
+		return *current, nil
+	}
+
+	hub.sendToMembers(chat, newEvent(EventReadMarked, chat.ID, "", cursor))
+	return cursor, nil
+}
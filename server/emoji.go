@@ -0,0 +1,94 @@
+package server
+
+import "unicode/utf8"
+
+// Code points that join or adjust the emoji in a reaction.
+const (
+	zeroWidthJoiner   = '\u200D'
+	variationSelector = '\uFE0F' // asks for emoji rather than text presentation
+	combiningKeycap   = '\u20E3'
+	blackFlag         = '\U0001F3F4' // base of subdivision flags like England's
+	cancelTag         = '\U000E007F' // ends a subdivision flag's tag run
+)
+
+// emojiRanges are the code points that can start an emoji, roughly the
+// Extended_Pictographic property of the Unicode emoji data.
+var emojiRanges = [][2]rune{
+	{0x00A9, 0x00A9}, {0x00AE, 0x00AE}, {0x203C, 0x203C}, {0x2049, 0x2049},
+	{0x2122, 0x2122}, {0x2139, 0x2139}, {0x2194, 0x2199}, {0x21A9, 0x21AA},
+	{0x231A, 0x231B}, {0x2328, 0x2328}, {0x23CF, 0x23CF}, {0x23E9, 0x23F3},
+	{0x23F8, 0x23FA}, {0x24C2, 0x24C2}, {0x25AA, 0x25AB}, {0x25B6, 0x25B6},
+	{0x25C0, 0x25C0}, {0x25FB, 0x25FE}, {0x2600, 0x27BF}, {0x2934, 0x2935},
+	{0x2B05, 0x2B07}, {0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55},
+	{0x3030, 0x3030}, {0x303D, 0x303D}, {0x3297, 0x3297}, {0x3299, 0x3299},
+	{0x1F000, 0x1F1E5}, {0x1F200, 0x1F3FA}, {0x1F400, 0x1FAFF},
+}
+
+func isEmojiBase(r rune) bool {
+	for _, rg := range emojiRanges {
+		if r >= rg[0] && r <= rg[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1F1E6 && r <= 0x1F1FF }
+func isSkinTone(r rune) bool          { return r >= 0x1F3FB && r <= 0x1F3FF }
+func isTag(r rune) bool               { return r >= 0xE0020 && r <= 0xE007E }
+
+// isEmoji reports whether s is exactly one emoji: a keycap, a flag, or a
+// pictograph with an optional presentation selector and skin tone, any
+// number of which may be joined into one by zero-width joiners.
+func isEmoji(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	rs := []rune(s)
+	switch {
+	case len(rs) == 0:
+		return false
+	case len(rs) == 2 && isRegionalIndicator(rs[0]) && isRegionalIndicator(rs[1]):
+		return true
+	case rs[len(rs)-1] == combiningKeycap:
+		return isKeycap(rs)
+	case rs[0] == blackFlag && len(rs) > 2 && rs[len(rs)-1] == cancelTag:
+		for _, r := range rs[1 : len(rs)-1] {
+			if !isTag(r) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := 0; ; i++ {
+		if i >= len(rs) || !isEmojiBase(rs[i]) {
+			return false
+		}
+		if i+1 < len(rs) && rs[i+1] == variationSelector {
+			i++
+		}
+		if i+1 < len(rs) && isSkinTone(rs[i+1]) {
+			i++
+		}
+		if i+1 == len(rs) {
+			return true
+		}
+		if rs[i+1] != zeroWidthJoiner {
+			return false
+		}
+		i++
+	}
+}
+
+// isKeycap reports whether rs is a keycap, like 1️⃣ or #️⃣.
+func isKeycap(rs []rune) bool {
+	if len(rs) == 3 && rs[1] != variationSelector {
+		return false
+	}
+	if len(rs) != 2 && len(rs) != 3 {
+		return false
+	}
+	r := rs[0]
+	return r == '#' || r == '*' || (r >= '0' && r <= '9')
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// crockford is the ULID alphabet. Its characters are in ASCII order, so
+// encoded IDs sort the same way as the bytes they encode.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var idGen = struct {
+	sync.Mutex
+	lastMs  uint64
+	entropy [10]byte
+}{}
+
+// NewID returns a prefixed, K-sortable ID such as "m_01J9Z3...": a ULID
+// whose first 48 bits are the creation time in milliseconds and whose
+// remaining 80 bits are random. IDs minted in the same millisecond by this
+// process increment the random part, so they still sort in creation order.
+func NewID(prefix string) string {
+	idGen.Lock()
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= idGen.lastMs {
+		ms = idGen.lastMs
+		incrementEntropy(&idGen.entropy)
+	} else {
+		idGen.lastMs = ms
+		rand.Read(idGen.entropy[:])
+	}
+
+	var raw [16]byte
+	for i := 0; i < 6; i++ {
+		raw[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(raw[6:], idGen.entropy[:])
+	idGen.Unlock()
+
+	return prefix + "_" + encodeULID(raw)
+}
+
+// incrementEntropy adds one to the random part, carrying across bytes.
+func incrementEntropy(b *[10]byte) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
+
+// encodeULID writes 128 bits as 26 Crockford base32 characters, the first
+// of which only carries the top 3 bits.
+func encodeULID(raw [16]byte) string {
+	var out [26]byte
+	var acc uint64
+	bits := 2 // pad the front so 128 bits split evenly into 130
+	j := 0
+	for _, b := range raw {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[j] = crockford[(acc>>bits)&31]
+			j++
+		}
+	}
+	return string(out[:])
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"errors"
+	"fluffy-coto-tribble/server/services"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// errMediaForbidden rejects a message carrying a file its sender may not post.
+var errMediaForbidden = errors.New("media must be files you uploaded or that were already posted to this chat")
+
+// authorizeMedia checks that userID may post each file key in media to
+// chatID: they uploaded it, or it has already been posted there.
+func authorizeMedia(client *dynamodb.Client, userID, chatID string, media []string) error {
+	for _, key := range media {
+		owned, err := services.OwnsFile(client, "files", userID, key)
+		if err != nil {
+			return err
+		}
+		if owned {
+			continue
+		}
+		shared, err := services.IsFileShared(client, "file_shares", key, chatID)
+		if err != nil {
+			return err
+		}
+		if !shared {
+			return errMediaForbidden
+		}
+	}
+	return nil
+}
+
+// canDownload reports whether userID may fetch a file: they uploaded it, or
+// it was posted to a chat they belong to.
+func canDownload(client *dynamodb.Client, userID, fileKey string) (bool, error) {
+	owned, err := services.OwnsFile(client, "files", userID, fileKey)
+	if err != nil || owned {
+		return owned, err
+	}
+
+	chatIDs, err := services.GetFileShareChats(client, "file_shares", fileKey)
+	if err != nil {
+		return false, err
+	}
+	for _, chatID := range chatIDs {
+		member, err := services.IsChatMember(client, "chat_members", userID, chatID)
+		if err != nil || member {
+			return member, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"fluffy-coto-tribble/server/services"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// errInvalidMention rejects an explicit mention of someone outside the chat.
+var errInvalidMention = errors.New("mentions must name members of the chat")
+
+// mentionPattern finds @userId tokens in message content.
+var mentionPattern = regexp.MustCompile(`@(u_[0-9A-Za-z_-]+)`)
+
+const (
+	mentionPreviewLength   = 140
+	defaultMentionPageSize = 20
+	maxMentionPageSize     = 100
+)
+
+// resolveMentions works out who a message from senderID mentions: the
+// users listed in requested, which must all be members of chat, plus any
+// @userId in content that names a member. Tokens naming anyone else are
+// left as plain text. The sender never mentions themselves.
+func resolveMentions(chat *services.Chat, senderID, content string, requested []string) ([]string, error) {
+	var mentions []string
+	for _, id := range requested {
+		if !slices.Contains(chat.Users, id) {
+			return nil, errInvalidMention
+		}
+		mentions = append(mentions, id)
+	}
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		if slices.Contains(chat.Users, match[1]) {
+			mentions = append(mentions, match[1])
+		}
+	}
+
+	mentions = slices.DeleteFunc(mentions, func(id string) bool { return id == senderID })
+	slices.Sort(mentions)
+	return slices.Compact(mentions), nil
+}
+
+// notifyMentions puts msg in the inbox of each of userIDs and pushes a
+// mention event to them wherever they're connected, whether or not they
+// have the chat open.
+func notifyMentions(client *dynamodb.Client, hub *Hub, msg services.Message, userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	preview := []rune(msg.Content)
+	if len(preview) > mentionPreviewLength {
+		preview = preview[:mentionPreviewLength]
+	}
+
+	mentions := make([]services.Mention, 0, len(userIDs))
+	for _, userID := range userIDs {
+		mentions = append(mentions, services.Mention{
+			UserID:    userID,
+			MessageID: msg.ID,
+			ChatID:    msg.ChatID,
+			ParentID:  msg.ParentID,
+			SenderID:  msg.SenderID,
+			Preview:   string(preview),
+			Timestamp: msg.Timestamp,
+		})
+	}
+
+	if err := services.AddMentions(client, "mentions", mentions); err != nil {
+		log.Printf("Failed to record mentions in %s: %v\n", msg.ID, err)
+	}
+	for _, m := range mentions {
+		hub.sendToUser(m.UserID, newEvent(EventMention, m.ChatID, "", m))
+	}
+}
+
+// forgetMentions takes msg out of the inboxes of userIDs.
+func forgetMentions(client *dynamodb.Client, msg services.Message, userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	if err := services.RemoveMentions(client, "mentions", msg.ID, userIDs); err != nil {
+		log.Printf("Failed to remove mentions in %s: %v\n", msg.ID, err)
+	}
+}
+
+// GetMentions returns one page of the caller's mentions, newest first.
+// Mentions in chats they've since left are skipped.
+func GetMentions(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := currentClaims(c)
+
+		limit := defaultMentionPageSize
+		if raw := c.Query("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+			limit = min(n, maxMentionPageSize)
+		}
+
+		startKey, err := scopedCursor(c.Query("cursor"), map[string]string{"userId": claims.ID})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		mentions, lastKey, err := services.GetMentionPage(client, "mentions", claims.ID, int32(limit), startKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ids []string
+		for _, m := range mentions {
+			if !slices.Contains(ids, m.ChatID) {
+				ids = append(ids, m.ChatID)
+			}
+		}
+		chats, err := services.GetChatsByIds(client, "chats", ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		member := map[string]bool{}
+		for _, chat := range chats {
+			member[chat.ID] = slices.Contains(chat.Users, claims.ID)
+		}
+		mentions = slices.DeleteFunc(mentions, func(m services.Mention) bool { return !member[m.ChatID] })
+
+		nextCursor, err := services.EncodeCursor(lastKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if mentions == nil {
+			mentions = []services.Mention{}
+		}
+		c.JSON(http.StatusOK, gin.H{"mentions": mentions, "nextCursor": nextCursor})
+	}
+}
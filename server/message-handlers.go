@@ -1,38 +1,353 @@
 package server
 
 import (
+	"errors"
+	"fluffy-coto-tribble/server/authentication"
 	"fluffy-coto-tribble/server/services"
 	"fmt"
+	"log"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/gin-gonic/gin"
 )
 
-func CreateMessage(client *dynamodb.Client) gin.HandlerFunc {
+// errInvalidParent rejects a reply to something that can't hold a thread.
+var errInvalidParent = errors.New("replies must point at a top-level message in the same chat")
+
+// errInvalidQuote rejects a quote of a message the sender can't see.
+var errInvalidQuote = errors.New("quotes must point at a visible message in the same chat")
+
+// ThreadSummary is the reply count and latest reply of a thread, sent as
+// thread.updated whenever it changes.
+type ThreadSummary struct {
+	ParentID    string `json:"parentId"`
+	ReplyCount  int    `json:"replyCount"`
+	LastReplyID string `json:"lastReplyId,omitempty"`
+	LastReplyBy string `json:"lastReplyBy,omitempty"`
+	LastReplyAt int64  `json:"lastReplyAt,omitempty"`
+}
+
+func threadSummary(parent *services.Message) ThreadSummary {
+	return ThreadSummary{
+		ParentID:    parent.ID,
+		ReplyCount:  parent.ReplyCount,
+		LastReplyID: parent.LastReplyID,
+		LastReplyBy: parent.LastReplyBy,
+		LastReplyAt: parent.LastReplyAt,
+	}
+}
+
+// saveMessage assigns an ID and timestamp to msg, stores it, bumps the
+// chat's last activity and pushes message.created to its participants.
+// A reply instead goes out as thread.replied to the thread's subscribers,
+// with thread.updated telling every participant about the new count. A
+// msg.Quote only needs its ID set; it's replaced with a snapshot of the
+// quoted message. msg.Mentions holds the mentions the client listed; the
+// message mentions them and any member named in its content, each of whom
+// is notified. Forwards mention no one.
+func saveMessage(client *dynamodb.Client, hub *Hub, chat *services.Chat, msg services.Message) (services.Message, error) {
+	if msg.ParentID != "" {
+		parent, err := services.GetChatMessage(client, "messages", chat.ID, msg.ParentID)
+		if err != nil || parent.ParentID != "" || parent.DeletedAt != 0 {
+			return services.Message{}, errInvalidParent
+		}
+	}
+
+	var quote *services.QuotedMessage
+	if msg.Quote != nil {
+		quoted, err := services.GetChatMessage(client, "messages", chat.ID, msg.Quote.ID)
+		if err != nil || quoted.DeletedAt != 0 || slices.Contains(quoted.HiddenFor, msg.SenderID) {
+			return services.Message{}, errInvalidQuote
+		}
+		quote = &services.QuotedMessage{
+			ID:        quoted.ID,
+			SenderID:  quoted.SenderID,
+			Content:   quoted.Content,
+			Media:     quoted.Media,
+			Timestamp: quoted.Timestamp,
+		}
+	}
+
+	var mentions []string
+	if msg.ForwardedFrom == nil {
+		var err error
+		if mentions, err = resolveMentions(chat, msg.SenderID, msg.Content, msg.Mentions); err != nil {
+			return services.Message{}, err
+		}
+	}
+
+	// a forward's files were checked against the chat it came from
+	if msg.ForwardedFrom == nil {
+		if err := authorizeMedia(client, msg.SenderID, chat.ID, msg.Media); err != nil {
+			return services.Message{}, err
+		}
+	}
+
+	newMessage := services.Message{
+		ID:        NewID("m"),
+		ChatID:    chat.ID,
+		SenderID:  msg.SenderID,
+		Content:   msg.Content,
+		Media:     msg.Media,
+		Timestamp: time.Now().Unix(),
+		ParentID:  msg.ParentID,
+		Mentions:  mentions,
+
+		Quote:         quote,
+		ForwardedFrom: msg.ForwardedFrom,
+	}
+
+	if err := services.CreateMessage(client, "messages", newMessage); err != nil {
+		return services.Message{}, err
+	}
+	hub.indexMessage(newMessage)
+	if err := services.ShareFiles(client, "file_shares", chat.ID, newMessage.Media, newMessage.ID, newMessage.SenderID, newMessage.Timestamp); err != nil {
+		log.Printf("Failed to share media of %s: %v\n", newMessage.ID, err)
+	}
+
+	if err := services.TouchChatMembers(client, "chat_members", chat.ID, chat.Users, newMessage.Timestamp); err != nil {
+		log.Printf("Failed to bump activity for %s: %v\n", chat.ID, err)
+	}
+
+	if newMessage.ParentID == "" {
+		hub.sendToMembers(chat, newEvent(EventMessageCreated, chat.ID, "", newMessage))
+		notifyMentions(client, hub, newMessage, newMessage.Mentions)
+		return newMessage, nil
+	}
+
+	hub.sendToThread(chat, newMessage.ParentID, newEvent(EventThreadReplied, chat.ID, "", newMessage))
+	notifyMentions(client, hub, newMessage, newMessage.Mentions)
+	parent, err := services.AddReply(client, "messages", newMessage)
+	if err != nil {
+		log.Printf("Failed to count reply %s: %v\n", newMessage.ID, err)
+		return newMessage, nil
+	}
+	hub.sendToMembers(chat, newEvent(EventThreadUpdated, chat.ID, "", threadSummary(parent)))
+	return newMessage, nil
+}
+
+// forwardMessage posts a copy of msg to dest on behalf of userID. Its
+// files are authorized against the chat msg came from, since the
+// forwarder may not own them, and saveMessage shares them with dest once
+// the copy is stored. A forward of a forward credits the original message.
+func forwardMessage(client *dynamodb.Client, hub *Hub, msg services.Message, dest *services.Chat, userID string) (services.Message, error) {
+	if msg.DeletedAt != 0 {
+		return services.Message{}, services.ErrMessageDeleted
+	}
+	if err := authorizeMedia(client, userID, msg.ChatID, msg.Media); err != nil {
+		return services.Message{}, err
+	}
+
+	from := msg.ForwardedFrom
+	if from == nil {
+		from = &services.ForwardSource{MessageID: msg.ID, SenderID: msg.SenderID, Timestamp: msg.Timestamp}
+	}
+
+	return saveMessage(client, hub, dest, services.Message{
+		ChatID:        dest.ID,
+		SenderID:      userID,
+		Content:       msg.Content,
+		Media:         msg.Media,
+		ForwardedFrom: from,
+	})
+}
+
+// editMessage replaces the content and media of msg on behalf of its
+// sender, keeping the old version as a revision, and pushes message.updated
+// with the result. mentions replaces the explicit mentions, or keeps those
+// of members still in chat when nil; anyone newly mentioned is notified
+// and anyone no longer mentioned leaves the mention out of their inbox. An
+// edit that changes nothing is not recorded.
+func editMessage(client *dynamodb.Client, hub *Hub, chat *services.Chat, msg services.Message, content string, media, mentions []string) (*services.Message, error) {
+	if msg.DeletedAt != 0 {
+		return nil, services.ErrMessageDeleted
+	}
+	if mentions == nil {
+		mentions = slices.DeleteFunc(slices.Clone(msg.Mentions), func(id string) bool { return !slices.Contains(chat.Users, id) })
+	}
+	mentions, err := resolveMentions(chat, msg.SenderID, content, mentions)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := content != msg.Content || !slices.Equal(media, msg.Media)
+	if !changed && slices.Equal(mentions, msg.Mentions) {
+		return &msg, nil
+	}
+
+	edited := &msg
+	if changed {
+		if err := authorizeMedia(client, msg.SenderID, msg.ChatID, media); err != nil {
+			return nil, err
+		}
+		edited, err = services.EditMessage(client, "messages", "message_revisions", msg, content, media, msg.SenderID, time.Now().Unix())
+		if err != nil {
+			return nil, err
+		}
+		if err := services.ShareFiles(client, "file_shares", edited.ChatID, edited.Media, edited.ID, edited.SenderID, edited.EditedAt); err != nil {
+			log.Printf("Failed to share media of %s: %v\n", edited.ID, err)
+		}
+		hub.indexMessage(*edited)
+	}
+
+	if !slices.Equal(mentions, msg.Mentions) {
+		if err := services.SetMessageMentions(client, "messages", msg.ChatID, msg.ID, mentions); err != nil {
+			return nil, err
+		}
+		edited.Mentions = mentions
+		notifyMentions(client, hub, *edited, slices.DeleteFunc(slices.Clone(mentions), func(id string) bool { return slices.Contains(msg.Mentions, id) }))
+		forgetMentions(client, msg, slices.DeleteFunc(slices.Clone(msg.Mentions), func(id string) bool { return slices.Contains(mentions, id) }))
+	}
+
+	hub.publishMessage(EventMessageUpdated, *edited)
+	return edited, nil
+}
+
+// Delete modes: "me" hides a message from the caller alone, "everyone"
+// leaves a tombstone in its place for the whole chat.
+const (
+	DeleteForMe       = "me"
+	DeleteForEveryone = "everyone"
+)
+
+// deleteMessage deletes msg in the given mode on behalf of userID. Hiding
+// pushes message.hidden to the user's own connections so their other
+// devices follow; a delete for everyone pushes message.deleted with the
+// tombstone to the chat's participants.
+func deleteMessage(client *dynamodb.Client, hub *Hub, chat *services.Chat, msg services.Message, userID, mode string) (*services.Message, error) {
+	if mode == DeleteForMe {
+		if err := services.HideMessage(client, "messages", msg.ChatID, msg.ID, userID); err != nil {
+			return nil, err
+		}
+		hub.sendToUser(userID, newEvent(EventMessageHidden, msg.ChatID, "", map[string]string{"id": msg.ID}))
+		msg.HiddenFor = append(msg.HiddenFor, userID)
+		hub.indexMessage(msg)
+		if slices.Contains(msg.Mentions, userID) {
+			forgetMentions(client, msg, []string{userID})
+		}
+		return nil, nil
+	}
+
+	deleted, err := services.SoftDeleteMessage(client, "messages", msg.ChatID, msg.ID, userID, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	hub.unindexMessage(deleted.ID)
+	tomb := tombstone(*deleted)
+	hub.sendToMembers(chat, newEvent(EventMessageDeleted, chat.ID, "", tomb))
+	forgetMentions(client, *deleted, deleted.Mentions)
+
+	if deleted.ParentID != "" {
+		parent, err := services.RemoveReply(client, "messages", *deleted)
+		if err != nil {
+			log.Printf("Failed to uncount reply %s: %v\n", deleted.ID, err)
+		} else {
+			hub.sendToMembers(chat, newEvent(EventThreadUpdated, chat.ID, "", threadSummary(parent)))
+		}
+	}
+	return &tomb, nil
+}
+
+// tombstone strips what a deleted message said, leaving who deleted it and when.
+func tombstone(msg services.Message) services.Message {
+	msg.Content = ""
+	msg.Media = nil
+	msg.ReactedBy = nil
+	return msg
+}
+
+// messageView is msg as the given user may see it: nil if they deleted it
+// for themselves, and a tombstone if it was deleted for everyone, unless
+// they're staff keeping the content as moderation evidence.
+func messageView(msg services.Message, claims *authentication.UserClaims) *services.Message {
+	if slices.Contains(msg.HiddenFor, claims.ID) {
+		return nil
+	}
+	if msg.DeletedAt != 0 && !claims.HasRole(services.RoleModerator, services.RoleAdmin) {
+		msg = tombstone(msg)
+	}
+	summarizeReactions(&msg, claims.ID)
+	return &msg
+}
+
+func messagesView(msgs []services.Message, claims *authentication.UserClaims) []services.Message {
+	out := make([]services.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if view := messageView(msg, claims); view != nil {
+			out = append(out, *view)
+		}
+	}
+	return out
+}
+
+// messageCreate is the body of a new message. QuoteID optionally names a
+// message in the same chat to quote, and Mentions members to mention on
+// top of any @userId in Content.
+type messageCreate struct {
+	ChatID   string   `json:"chatId"`
+	Content  string   `json:"content"`
+	Media    []string `json:"media"`
+	ParentID string   `json:"parentId"`
+	QuoteID  string   `json:"quoteId"`
+	Mentions []string `json:"mentions"`
+}
+
+// newMessage builds the message saveMessage expects from what a client sent.
+func (req messageCreate) newMessage(senderID string) services.Message {
+	msg := services.Message{
+		ChatID:   req.ChatID,
+		SenderID: senderID,
+		Content:  req.Content,
+		Media:    req.Media,
+		ParentID: req.ParentID,
+		Mentions: req.Mentions,
+	}
+	if req.QuoteID != "" {
+		msg.Quote = &services.QuotedMessage{ID: req.QuoteID}
+	}
+	return msg
+}
+
+// saveErrorStatus maps an error from saveMessage onto an HTTP status.
+func saveErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidParent), errors.Is(err, errInvalidQuote), errors.Is(err, errInvalidMention):
+		return http.StatusBadRequest
+	case errors.Is(err, errMediaForbidden):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func CreateMessage(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var msg services.Message
-		if err := c.ShouldBindJSON(&msg); err != nil {
+		var req messageCreate
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 			return
 		}
 
-		id := fmt.Sprintf("m_%s", ShortUUID())
-		now := time.Now().Unix()
-
-		newMessage := services.Message{
-			ID:        id,
-			ChatID:    msg.ChatID,
-			SenderID:  msg.SenderID,
-			Content:   msg.Content,
-			Media:     msg.Media,
-			Timestamp: now,
+		claims := currentClaims(c)
+		chat, err := services.GetChatById(client, "chats", req.ChatID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if !slices.Contains(chat.Users, claims.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this chat"})
+			return
 		}
 
-		if err := services.CreateMessage(client, "messages", newMessage); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		// the sender is whoever holds the token, not whatever the body claims
+		newMessage, err := saveMessage(client, hub, chat, req.newMessage(claims.ID))
+		if err != nil {
+			c.JSON(saveErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
@@ -43,6 +358,54 @@ func CreateMessage(client *dynamodb.Client) gin.HandlerFunc {
 	}
 }
 
+// ForwardMessage copies a message, media included, into another chat the
+// caller belongs to, crediting the original sender.
+func ForwardMessage(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			ChatID string `json:"chatId" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		claims := currentClaims(c)
+		source := currentChat(c)
+
+		dest, err := services.GetChatById(client, "chats", req.ChatID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if !slices.Contains(dest.Users, claims.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of the destination chat"})
+			return
+		}
+
+		msg, err := services.GetChatMessage(client, "messages", source.ID, c.Param("id"))
+		if err != nil || messageView(*msg, claims) == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+
+		forwarded, err := forwardMessage(client, hub, *msg, dest, claims.ID)
+		if errors.Is(err, services.ErrMessageDeleted) {
+			c.JSON(http.StatusGone, gin.H{"error": "Message has been deleted"})
+			return
+		}
+		if err != nil {
+			c.JSON(saveErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Message forwarded successfully",
+			"data":    forwarded,
+		})
+	}
+}
+
 func GetChatMessage(client *dynamodb.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		chatID := c.Param("chatId")
@@ -53,61 +416,298 @@ func GetChatMessage(client *dynamodb.Client) gin.HandlerFunc {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
+		view := messageView(*msg, currentClaims(c))
+		if view == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{"message": msg})
+		c.JSON(http.StatusOK, gin.H{"message": view})
 	}
 }
 
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 200
+)
+
+// GetAllChatMessages returns one page of a chat's top-level history,
+// oldest first. With no cursor it's the latest page; ?before= pages back
+// through older messages and ?after= forward through newer ones, each
+// taking a cursor from a previous response.
 func GetAllChatMessages(client *dynamodb.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		chatID := c.Param("chatId")
+		chatID := currentChat(c).ID
 
-		messages, err := services.GetAllChatMessages(client, "messages", chatID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		page, ok := pageMessages(c, map[string]string{"chatId": chatID}, services.MessageKey,
+			func(startKey map[string]types.AttributeValue, limit int32, forward bool) ([]services.Message, bool, error) {
+				return services.GetChatMessagePage(client, "messages", chatID, startKey, limit, forward)
+			})
+		if !ok {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"messages": messages})
+		c.JSON(http.StatusOK, page)
 	}
 }
 
-func UpdateMessage(client *dynamodb.Client) gin.HandlerFunc {
+// GetThread returns a message with one page of its replies, paged like
+// GetAllChatMessages.
+func GetThread(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatID := currentChat(c).ID
+		claims := currentClaims(c)
+
+		parent, err := services.GetChatMessage(client, "messages", chatID, c.Param("id"))
+		if err != nil || messageView(*parent, claims) == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+
+		page, ok := pageMessages(c, map[string]string{"chatId": chatID, "parentId": parent.ID}, services.ReplyKey,
+			func(startKey map[string]types.AttributeValue, limit int32, forward bool) ([]services.Message, bool, error) {
+				return services.GetThreadPage(client, "messages", parent.ID, startKey, limit, forward)
+			})
+		if !ok {
+			return
+		}
+
+		page["parent"] = messageView(*parent, claims)
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// messagePager reads one page of messages after startKey.
+type messagePager func(startKey map[string]types.AttributeValue, limit int32, forward bool) ([]services.Message, bool, error)
+
+// pageMessages reads the page a history request asks for through fetch
+// and builds the response, with the before and after cursors made by keyOf.
+// scope lists attributes a cursor must carry to be accepted here. On a bad
+// request it writes the error itself and reports false.
+func pageMessages(c *gin.Context, scope map[string]string, keyOf func(services.Message) map[string]types.AttributeValue, fetch messagePager) (gin.H, bool) {
+	limit := defaultMessagePageSize
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return nil, false
+		}
+		limit = min(n, maxMessagePageSize)
+	}
+
+	before, after := c.Query("before"), c.Query("after")
+	if before != "" && after != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use either before or after, not both"})
+		return nil, false
+	}
+	forward := after != ""
+
+	startKey, err := scopedCursor(before+after, scope)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	messages, more, err := fetch(startKey, int32(limit), forward)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !forward {
+		slices.Reverse(messages)
+	}
+
+	// older pages run out, newer ones never do since messages keep
+	// arriving, so an empty page hands back the cursor it was given
+	var olderKey, newerKey map[string]types.AttributeValue
+	if len(messages) > 0 {
+		if forward || more {
+			olderKey = keyOf(messages[0])
+		}
+		newerKey = keyOf(messages[len(messages)-1])
+	} else if forward {
+		newerKey = startKey
+	}
+
+	prevCursor, err := services.EncodeCursor(olderKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	nextCursor, err := services.EncodeCursor(newerKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return gin.H{
+		"messages": messagesView(messages, currentClaims(c)),
+		"before":   prevCursor,
+		"after":    nextCursor,
+	}, true
+}
+
+// scopedCursor decodes a pagination cursor, refusing one minted for another
+// chat, thread or user than scope describes.
+func scopedCursor(cursor string, scope map[string]string) (map[string]types.AttributeValue, error) {
+	key, err := services.DecodeCursor(cursor)
+	if err != nil || key == nil {
+		return key, err
+	}
+	for name, want := range scope {
+		if v, ok := key[name].(*types.AttributeValueMemberS); !ok || v.Value != want {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+	}
+	return key, nil
+}
+
+// messageEdit is the body of an edit. Fields left out keep their current value.
+type messageEdit struct {
+	Content  *string   `json:"content"`
+	Media    *[]string `json:"media"`
+	Mentions *[]string `json:"mentions"`
+}
+
+// mentions is the explicit mention list the edit asks for, or nil to keep
+// the current one.
+func (req messageEdit) mentions() []string {
+	if req.Mentions == nil {
+		return nil
+	}
+	return append([]string{}, *req.Mentions...)
+}
+
+// EditMessage lets the sender change the content or media of their message.
+func EditMessage(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		chatID := c.Param("chatId")
 		msgID := c.Param("id")
 
-		var updates map[string]interface{}
-		if err := c.ShouldBindJSON(&updates); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		msg, err := services.GetChatMessage(client, "messages", chatID, msgID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if msg.SenderID != currentClaims(c).ID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the sender can edit this message"})
 			return
 		}
 
-		avUpdates, err := attributevalue.MarshalMap(updates)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal updates"})
+		var req messageEdit
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		content, media := msg.Content, msg.Media
+		if req.Content != nil {
+			content = *req.Content
+		}
+		if req.Media != nil {
+			media = *req.Media
+		}
+		if content == "" && len(media) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A message needs content or media"})
 			return
 		}
 
-		if err := services.UpdateMessage(client, "messages", chatID, msgID, avUpdates); err != nil {
+		edited, err := editMessage(client, hub, currentChat(c), *msg, content, media, req.mentions())
+		if errors.Is(err, errInvalidMention) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, errMediaForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrMessageDeleted) {
+			c.JSON(http.StatusGone, gin.H{"error": "Message has been deleted"})
+			return
+		}
+		if errors.Is(err, services.ErrMessageChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Message was changed by another request, reload and retry"})
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Message updated successfully"})
+		c.JSON(http.StatusOK, gin.H{"message": "Message updated successfully", "data": edited})
 	}
 }
 
-func DeleteMessage(client *dynamodb.Client) gin.HandlerFunc {
+// GetMessageHistory returns a message with every earlier version of it.
+func GetMessageHistory(client *dynamodb.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		chatID := c.Param("chatId")
 		msgID := c.Param("id")
 
-		if err := services.DeleteMessage(client, "messages", chatID, msgID); err != nil {
+		msg, err := services.GetChatMessage(client, "messages", chatID, msgID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims := currentClaims(c)
+		view := messageView(*msg, claims)
+		if view == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+
+		revisions := []services.MessageRevision{}
+		if view.DeletedAt == 0 || claims.HasRole(services.RoleModerator, services.RoleAdmin) {
+			revisions, err = services.GetMessageRevisions(client, "message_revisions", msg.ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": view, "revisions": revisions})
+	}
+}
+
+// DeleteMessage deletes a message for the caller alone with ?mode=me, or
+// for everyone (the default), which only its sender or a moderator may do.
+func DeleteMessage(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chat := currentChat(c)
+		msgID := c.Param("id")
+		claims := currentClaims(c)
+
+		mode := c.DefaultQuery("mode", DeleteForEveryone)
+		if mode != DeleteForMe && mode != DeleteForEveryone {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be me or everyone"})
+			return
+		}
+
+		msg, err := services.GetChatMessage(client, "messages", chat.ID, msgID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		switch {
+		case mode == DeleteForMe && !slices.Contains(chat.Users, claims.ID):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this chat"})
+			return
+		case mode == DeleteForEveryone && !claims.CanActFor(msg.SenderID) && !claims.HasRole(services.RoleModerator):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the sender or a moderator can delete this message"})
+			return
+		}
+
+		deleted, err := deleteMessage(client, hub, chat, *msg, claims.ID, mode)
+		if errors.Is(err, services.ErrMessageDeleted) {
+			c.JSON(http.StatusGone, gin.H{"error": "Message has already been deleted"})
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
+		c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully", "data": deleted})
 	}
 }
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"fluffy-coto-tribble/server/services"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+var (
+	MessageRetention     = 30 * 24 * time.Hour // how long a message deleted for everyone is kept before it's purged
+	MessagePurgeInterval = time.Hour           // how often purgeDeletedMessages looks for expired tombstones
+)
+
+// LoadPurgeConfig overrides the purge defaults from MESSAGE_RETENTION and
+// MESSAGE_PURGE_INTERVAL (durations like "720h").
+func LoadPurgeConfig() {
+	MessageRetention = envDuration("MESSAGE_RETENTION", MessageRetention)
+	MessagePurgeInterval = envDuration("MESSAGE_PURGE_INTERVAL", MessagePurgeInterval)
+}
+
+// purgeDeletedMessages periodically hard-deletes messages whose tombstone
+// is older than MessageRetention. Every instance runs it, but only the one
+// holding the purge lock does any work; it renews the lock each run, and
+// another instance takes over once it's gone for two intervals.
+func purgeDeletedMessages(client *dynamodb.Client, hub *Hub) {
+	ticker := time.NewTicker(MessagePurgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		err := services.AcquireLock(client, "locks", "message-purge", hub.instance, now.Unix(), now.Add(2*MessagePurgeInterval).Unix())
+		if errors.Is(err, services.ErrLockHeld) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Message purge skipped: %v\n", err)
+			continue
+		}
+
+		cutoff := now.Add(-MessageRetention).Unix()
+		purged, err := services.PurgeDeletedMessages(client, "messages", "message_revisions", "file_shares", "mentions", cutoff)
+		if err != nil {
+			log.Printf("Message purge failed: %v\n", err)
+		}
+		for _, msg := range purged {
+			hub.unindexMessage(msg.ID)
+		}
+		if len(purged) > 0 {
+			log.Printf("Purged %d deleted messages\n", len(purged))
+		}
+	}
+}
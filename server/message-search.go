@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fluffy-coto-tribble/server/search"
+	"fluffy-coto-tribble/server/services"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+// messageIndex is the full-text index behind message search, replaced by
+// LoadSearchIndex at startup.
+var messageIndex search.Index = search.NewMemoryIndex()
+
+// LoadSearchIndex picks the search backend named by SEARCH_INDEX and fills
+// it from the messages table in the background. "memory", the default and
+// only backend so far, is embedded in each instance; every change to it is
+// published through the hub's broker so each instance's copy takes it in.
+func LoadSearchIndex(client *dynamodb.Client) {
+	switch backend := os.Getenv("SEARCH_INDEX"); backend {
+	case "", "memory":
+		messageIndex = search.NewMemoryIndex()
+	default:
+		log.Fatalf("unknown SEARCH_INDEX %q", backend)
+	}
+
+	go func() {
+		count := 0
+		err := services.ForEachMessage(client, "messages", func(page []services.Message) error {
+			for _, msg := range page {
+				if err := messageIndex.Backfill(messageDocument(msg)); err != nil {
+					return err
+				}
+			}
+			count += len(page)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to build search index: %v\n", err)
+			return
+		}
+		log.Printf("Indexed %d messages for search\n", count)
+	}()
+}
+
+// searchUpdate is a change to the search index as it travels through the
+// broker: a document to index, or the ID of one to delete.
+type searchUpdate struct {
+	Document *search.Document `json:"document,omitempty"`
+	Delete   string           `json:"delete,omitempty"`
+}
+
+func messageDocument(msg services.Message) search.Document {
+	return search.Document{
+		ID:        msg.ID,
+		ChatID:    msg.ChatID,
+		ParentID:  msg.ParentID,
+		SenderID:  msg.SenderID,
+		Content:   msg.Content,
+		Timestamp: msg.Timestamp,
+		Version:   max(msg.EditedAt, msg.Timestamp),
+		HiddenFor: msg.HiddenFor,
+	}
+}
+
+// indexMessage brings msg's entry in every instance's search index up to
+// date. Deleted messages are taken out of it.
+func (h *Hub) indexMessage(msg services.Message) {
+	if msg.DeletedAt != 0 {
+		h.unindexMessage(msg.ID)
+		return
+	}
+	doc := messageDocument(msg)
+	h.publishSearch(searchUpdate{Document: &doc})
+}
+
+func (h *Hub) unindexMessage(id string) {
+	h.publishSearch(searchUpdate{Delete: id})
+}
+
+// publishSearch sends an index change through the broker. If the broker is
+// down it's applied here alone, like a fan-out delivery.
+func (h *Hub) publishSearch(u searchUpdate) {
+	data, err := json.Marshal(wireDelivery{Search: &u})
+	if err == nil {
+		err = h.broker.Publish(data)
+	}
+	if err != nil {
+		log.Printf("Broker publish failed, indexing locally: %v\n", err)
+		applySearch(u)
+	}
+}
+
+// applySearch takes an index change from any instance into this one's index.
+func applySearch(u searchUpdate) {
+	if u.Document != nil {
+		if err := messageIndex.Index(*u.Document); err != nil {
+			log.Printf("Failed to index message %s: %v\n", u.Document.ID, err)
+		}
+		return
+	}
+	if err := messageIndex.Delete(u.Delete); err != nil {
+		log.Printf("Failed to unindex message %s: %v\n", u.Delete, err)
+	}
+}
+
+// SearchMessages searches the content of messages in the caller's chats,
+// or in the one named by ?chatId=. ?q= takes words, "quoted phrases" and
+// prefixes ending in *, all of which must match. Results are paged with
+// ?limit= and ?offset=.
+func SearchMessages(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := currentClaims(c)
+
+		q := c.Query("q")
+		if q == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+			return
+		}
+
+		limit := defaultSearchPageSize
+		if raw := c.Query("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+			limit = min(n, maxSearchPageSize)
+		}
+		offset := 0
+		if raw := c.Query("offset"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+				return
+			}
+			offset = n
+		}
+
+		var chatIDs []string
+		if chatID := c.Query("chatId"); chatID != "" {
+			member, err := services.IsChatMember(client, "chat_members", claims.ID, chatID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !member {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this chat"})
+				return
+			}
+			chatIDs = []string{chatID}
+		} else {
+			ids, err := services.GetUserChatIDs(client, "chat_members", claims.ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			chatIDs = ids
+		}
+
+		result, err := messageIndex.Search(search.Query{
+			Text:    q,
+			ChatIDs: chatIDs,
+			UserID:  claims.ID,
+			Offset:  offset,
+			Limit:   limit,
+		})
+		if errors.Is(err, search.ErrEmptyQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
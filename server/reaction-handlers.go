@@ -0,0 +1,165 @@
+package server
+
+import (
+	"errors"
+	"fluffy-coto-tribble/server/services"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// maxReactionLen caps an emoji reaction in bytes. Flags and skin tone or
+// ZWJ sequences run to a few code points, so it's generous on purpose.
+const maxReactionLen = 32
+
+// maxReactionKinds caps how many different emojis a message can collect.
+const maxReactionKinds = 20
+
+// ReactionUpdate is the payload of reaction.added and reaction.removed.
+type ReactionUpdate struct {
+	MessageID string `json:"messageId"`
+	Emoji     string `json:"emoji"`
+	UserID    string `json:"userId"`
+	Count     int    `json:"count"`
+}
+
+// validReaction accepts a single emoji of reasonable length.
+func validReaction(emoji string) bool {
+	return len(emoji) <= maxReactionLen && isEmoji(emoji)
+}
+
+// summarizeReactions fills in the per-emoji counts of msg and, given a
+// viewer, the emojis they reacted with.
+func summarizeReactions(msg *services.Message, viewerID string) {
+	msg.Reactions, msg.Reacted = nil, nil
+	if len(msg.ReactedBy) == 0 {
+		return
+	}
+
+	msg.Reactions = make(map[string]int, len(msg.ReactedBy))
+	for emoji, users := range msg.ReactedBy {
+		msg.Reactions[emoji] = len(users)
+		if viewerID != "" && slices.Contains(users, viewerID) {
+			msg.Reacted = append(msg.Reacted, emoji)
+		}
+	}
+	sort.Strings(msg.Reacted)
+}
+
+// react adds or removes userID's emoji reaction on a message and, if that
+// changed anything, pushes reaction.added or reaction.removed to the chat's
+// participants.
+func react(client *dynamodb.Client, hub *Hub, chat *services.Chat, msgID, emoji, userID string, add bool) (ReactionUpdate, error) {
+	update := ReactionUpdate{MessageID: msgID, Emoji: emoji, UserID: userID}
+
+	var err error
+	var changed bool
+	event := EventReactionAdded
+	if add {
+		update.Count, changed, err = services.AddReaction(client, "messages", chat.ID, msgID, emoji, userID, maxReactionKinds)
+	} else {
+		event = EventReactionRemoved
+		update.Count, changed, err = services.RemoveReaction(client, "messages", chat.ID, msgID, emoji, userID)
+	}
+	if err != nil {
+		return ReactionUpdate{}, err
+	}
+
+	if changed {
+		hub.sendToMembers(chat, newEvent(event, chat.ID, "", update))
+	}
+	return update, nil
+}
+
+// reactableMessage loads a message the caller can see and react to.
+func reactableMessage(c *gin.Context, client *dynamodb.Client) (*services.Message, bool) {
+	msg, err := services.GetChatMessage(client, "messages", currentChat(c).ID, c.Param("id"))
+	if err != nil || messageView(*msg, currentClaims(c)) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return nil, false
+	}
+	if msg.DeletedAt != 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "Message has been deleted"})
+		return nil, false
+	}
+	return msg, true
+}
+
+func AddReaction(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Emoji string `json:"emoji" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !validReaction(req.Emoji) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reaction"})
+			return
+		}
+
+		msg, ok := reactableMessage(c, client)
+		if !ok {
+			return
+		}
+
+		update, err := react(client, hub, currentChat(c), msg.ID, req.Emoji, currentClaims(c).ID, true)
+		if errors.Is(err, services.ErrMessageDeleted) {
+			c.JSON(http.StatusGone, gin.H{"error": "Message has been deleted"})
+			return
+		}
+		if errors.Is(err, services.ErrTooManyReactions) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"reaction": update})
+	}
+}
+
+func RemoveReaction(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		emoji := c.Param("emoji")
+		if !validReaction(emoji) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reaction"})
+			return
+		}
+
+		msg, ok := reactableMessage(c, client)
+		if !ok {
+			return
+		}
+
+		update, err := react(client, hub, currentChat(c), msg.ID, emoji, currentClaims(c).ID, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"reaction": update})
+	}
+}
+
+// GetReactions lists who reacted to a message with each emoji.
+func GetReactions(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		msg, ok := reactableMessage(c, client)
+		if !ok {
+			return
+		}
+
+		reactions := msg.ReactedBy
+		if reactions == nil {
+			reactions = map[string][]string{}
+		}
+		c.JSON(http.StatusOK, gin.H{"reactions": reactions})
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fluffy-coto-tribble/server/authentication"
+	"fluffy-coto-tribble/server/services"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -9,31 +10,66 @@ import (
 	"googlemaps.github.io/maps"
 )
 
-func AddDynamoDBRoutes(client *dynamodb.Client, r *gin.Engine) {
+func AddDynamoDBRoutes(client *dynamodb.Client, hub *Hub, r *gin.Engine) {
 	r.POST("/register", CreateUser(client))
 	r.POST("/login", AuthUser(client))
-	r.POST("/refresh-token", authentication.RefreshTokenHandler(client))
+	r.POST("/refresh-token", authentication.RefreshTokenHandler(client, hub.closeSessions))
 
 	auth := r.Group("/", authentication.AuthMiddleware())
 	{
+		auth.POST("/logout", Logout(client, hub))
+		// sessions
+		auth.GET("/sessions", GetSessions(client))
+		auth.DELETE("/sessions", DeleteAllSessions(client, hub))
+		auth.DELETE("/sessions/:id", DeleteSession(client, hub))
 		// users
 		auth.GET("/users", GetAllUsers(client))
 		auth.GET("/users/:id", GetUserByID(client))
+		auth.GET("/users/:id/presence", GetUserPresence(hub))
 		auth.PUT("/users", UpdateUser(client))
 		auth.PUT("/users/password", UpdatePassword(client))
-		auth.DELETE("/users/:id", DeleteUser(client))
+		auth.DELETE("/users/:id", DeleteUser(client, hub))
 		// chats
 		auth.POST("/chats", CreateChat(client))
 		auth.GET("/chats", GetAllChats(client))
-		auth.GET("/chats/:id", GetChatById(client))
-		auth.PUT("/chats/:id", UpdateChat(client))
-		auth.DELETE("/chats/:id", DeleteChat(client))
+
+		member := ChatAccess(client, "id")
+		staff := ChatAccess(client, "id", services.RoleModerator, services.RoleAdmin)
+		auth.GET("/chats/:id", member, GetChatById())
+		auth.PUT("/chats/:id", staff, UpdateChat(client))
+		auth.DELETE("/chats/:id", staff, DeleteChat(client))
+		auth.POST("/chats/:id/members", staff, AddChatMembers(client))
+		auth.DELETE("/chats/:id/members/:userId", staff, RemoveChatMember(client))
+		auth.POST("/chats/:id/read", member, MarkChatRead(client, hub))
+		auth.GET("/chats/:id/scheduled", member, GetScheduledMessages(client))
+		auth.POST("/chats/:id/scheduled", member, ScheduleMessage(client))
+		auth.PUT("/chats/:id/scheduled/:scheduledId", member, UpdateScheduledMessage(client))
+		auth.DELETE("/chats/:id/scheduled/:scheduledId", member, CancelScheduledMessage(client))
+		// mentions
+		auth.GET("/mentions", GetMentions(client))
+		// search
+		auth.GET("/search/messages", SearchMessages(client))
+
 		// messages
-		auth.POST("/messages", CreateMessage(client))
-		auth.GET("/messages/:chatId/:id", GetChatMessage(client))
-		auth.GET("/messages/:chatId", GetAllChatMessages(client))
-		auth.PUT("/messages/:chatId/:id", UpdateMessage(client))
-		auth.DELETE("/messages/:chatId/:id", DeleteMessage(client))
+		msgMember := ChatAccess(client, "chatId")
+		msgStaff := ChatAccess(client, "chatId", services.RoleModerator, services.RoleAdmin)
+		auth.POST("/messages", CreateMessage(client, hub))
+		auth.GET("/messages/:chatId/:id", msgMember, GetChatMessage(client))
+		auth.GET("/messages/:chatId", msgMember, GetAllChatMessages(client))
+		auth.GET("/messages/:chatId/:id/history", msgMember, GetMessageHistory(client))
+		auth.GET("/messages/:chatId/:id/thread", msgMember, GetThread(client))
+		auth.POST("/messages/:chatId/:id/forward", msgMember, ForwardMessage(client, hub))
+		auth.PUT("/messages/:chatId/:id", msgMember, EditMessage(client, hub))
+		auth.DELETE("/messages/:chatId/:id", msgStaff, DeleteMessage(client, hub))
+		auth.GET("/messages/:chatId/:id/reactions", msgMember, GetReactions(client))
+		auth.POST("/messages/:chatId/:id/reactions", msgMember, AddReaction(client, hub))
+		auth.DELETE("/messages/:chatId/:id/reactions/:emoji", msgMember, RemoveReaction(client, hub))
+	}
+
+	admin := r.Group("/admin", authentication.AuthMiddleware(), authentication.RequireRole(services.RoleAdmin))
+	{
+		admin.GET("/chats", AdminGetAllChats(client))
+		admin.PUT("/users/:id/role", SetUserRole(client))
 	}
 }
 
@@ -51,6 +87,6 @@ func AddS3Routes(s3client *s3.Client, dynamoclient *dynamodb.Client, r *gin.Engi
 	{
 		auth.POST("/upload", Upload(s3client, dynamoclient))
 		auth.GET("/files", GetUserFilesHandler(dynamoclient, s3.NewPresignClient(s3client)))
-		auth.GET("/download", Download(s3client))
+		auth.GET("/download", Download(s3client, dynamoclient))
 	}
 }
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fluffy-coto-tribble/server/authentication"
 	"fluffy-coto-tribble/server/services"
-	"fmt"
 	"net/http"
 	"os"
 	"strings"
@@ -41,8 +40,7 @@ func Upload(client *s3.Client, dynamo *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
-		id := ShortUUID()
-		fileID := fmt.Sprintf("f_%s", id)
+		fileID := NewID("f")
 		userID := claims.ID
 
 		file, header, err := c.Request.FormFile("file")
@@ -140,7 +138,9 @@ func GetUserFilesHandler(dynamo *dynamodb.Client, presigner *s3.PresignClient) g
 	}
 }
 
-func Download(client *s3.Client) gin.HandlerFunc {
+// Download presigns a file for callers who uploaded it or belong to a chat
+// it was posted to.
+func Download(client *s3.Client, dynamo *dynamodb.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		if c.Request.Method != http.MethodGet {
@@ -170,6 +170,16 @@ func Download(client *s3.Client) gin.HandlerFunc {
 			return
 		}
 
+		allowed, err := canDownload(dynamo, claims.ID, filename)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check file access"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have access to this file"})
+			return
+		}
+
 		url, err := services.DownloadFile(client, filename)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate download URL"})
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"errors"
+	"fluffy-coto-tribble/server/services"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ScheduledMessageInterval = 15 * time.Second     // how often sendScheduledMessages looks for due messages
+	MaxScheduleAhead         = 365 * 24 * time.Hour // how far ahead a message may be scheduled
+	ScheduledClaimLease      = 5 * time.Minute      // how long a message may stay claimed before it's given up on
+)
+
+// LoadScheduleConfig overrides the scheduler defaults from
+// SCHEDULED_MESSAGE_INTERVAL, MAX_SCHEDULE_AHEAD and SCHEDULED_CLAIM_LEASE
+// (durations like "30s").
+func LoadScheduleConfig() {
+	ScheduledMessageInterval = envDuration("SCHEDULED_MESSAGE_INTERVAL", ScheduledMessageInterval)
+	MaxScheduleAhead = envDuration("MAX_SCHEDULE_AHEAD", MaxScheduleAhead)
+	ScheduledClaimLease = envDuration("SCHEDULED_CLAIM_LEASE", ScheduledClaimLease)
+}
+
+// errScheduledRetry marks a send that failed for a reason that may pass,
+// like the database being briefly unavailable.
+var errScheduledRetry = errors.New("scheduled message will be retried")
+
+// errClaimExpired is the failure recorded for a message whose claim ran
+// out, most likely because its instance died while sending it.
+var errClaimExpired = errors.New("sending was interrupted and the message may not have been sent")
+
+// ScheduledResult tells a sender what became of a scheduled message, sent
+// to them as scheduled.sent or scheduled.failed.
+type ScheduledResult struct {
+	Scheduled services.ScheduledMessage `json:"scheduled"`
+	Message   *services.Message         `json:"message,omitempty"`
+}
+
+// sendScheduledMessages periodically sends scheduled messages that have
+// come due. Running it on every instance is harmless, each message is
+// claimed by one of them. A message is sent at most once: one whose
+// instance dies mid-send is marked failed once its claim is older than
+// ScheduledClaimLease, and left to its sender to send again or cancel.
+func sendScheduledMessages(client *dynamodb.Client, hub *Hub) {
+	ticker := time.NewTicker(ScheduledMessageInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expireScheduledClaims(client, hub)
+
+		now := time.Now().Unix()
+		due, err := services.GetDueScheduledMessages(client, "scheduled_messages", now)
+		if err != nil {
+			log.Printf("Scheduled message run failed: %v\n", err)
+			continue
+		}
+		for _, s := range due {
+			claimed, err := services.ClaimScheduledMessage(client, "scheduled_messages", s.ChatID, s.ID, now)
+			if errors.Is(err, services.ErrScheduledNotPending) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed to claim scheduled message %s: %v\n", s.ID, err)
+				continue
+			}
+			sendScheduled(client, hub, *claimed)
+		}
+	}
+}
+
+// expireScheduledClaims fails the messages claimed more than
+// ScheduledClaimLease ago and tells their senders.
+func expireScheduledClaims(client *dynamodb.Client, hub *Hub) {
+	before := time.Now().Add(-ScheduledClaimLease).Unix()
+	expired, err := services.ExpireScheduledClaims(client, "scheduled_messages", before, errClaimExpired.Error())
+	if err != nil {
+		log.Printf("Failed to expire scheduled message claims: %v\n", err)
+	}
+	for _, s := range expired {
+		hub.sendToUser(s.SenderID, newEvent(EventScheduledFailed, s.ChatID, "", ScheduledResult{Scheduled: s}))
+	}
+}
+
+// sendScheduled posts a claimed message through saveMessage as if its
+// sender had just sent it. If that fails for a passing reason the message
+// goes back to pending for the next run; otherwise it's kept as failed,
+// with the reason, until the sender edits or cancels it.
+func sendScheduled(client *dynamodb.Client, hub *Hub, s services.ScheduledMessage) {
+	msg, err := postScheduled(client, hub, s)
+	if errors.Is(err, errScheduledRetry) {
+		log.Printf("Retrying scheduled message %s: %v\n", s.ID, err)
+		if err := services.ReleaseScheduledMessage(client, "scheduled_messages", s.ChatID, s.ID); err != nil {
+			log.Printf("Failed to release scheduled message %s: %v\n", s.ID, err)
+		}
+		return
+	}
+	if err != nil {
+		s.Status, s.Error = services.ScheduledFailed, err.Error()
+		if err := services.FailScheduledMessage(client, "scheduled_messages", s.ChatID, s.ID, s.Error); err != nil {
+			log.Printf("Failed to record failure of scheduled message %s: %v\n", s.ID, err)
+		}
+		hub.sendToUser(s.SenderID, newEvent(EventScheduledFailed, s.ChatID, "", ScheduledResult{Scheduled: s}))
+		return
+	}
+
+	if err := services.DeleteScheduledMessage(client, "scheduled_messages", s.ChatID, s.ID); err != nil {
+		log.Printf("Failed to clear sent scheduled message %s: %v\n", s.ID, err)
+	}
+	hub.sendToUser(s.SenderID, newEvent(EventScheduledSent, s.ChatID, "", ScheduledResult{Scheduled: s, Message: &msg}))
+}
+
+func postScheduled(client *dynamodb.Client, hub *Hub, s services.ScheduledMessage) (services.Message, error) {
+	chat, err := services.GetChatById(client, "chats", s.ChatID)
+	if errors.Is(err, services.ErrChatNotFound) {
+		return services.Message{}, fmt.Errorf("chat no longer exists")
+	}
+	if err != nil {
+		return services.Message{}, fmt.Errorf("%w: %w", errScheduledRetry, err)
+	}
+	if !slices.Contains(chat.Users, s.SenderID) {
+		return services.Message{}, fmt.Errorf("sender is no longer a member of this chat")
+	}
+
+	req := messageCreate{
+		ChatID:   s.ChatID,
+		Content:  s.Content,
+		Media:    s.Media,
+		ParentID: s.ParentID,
+		QuoteID:  s.QuoteID,
+		Mentions: s.Mentions,
+	}
+	return saveMessage(client, hub, chat, req.newMessage(s.SenderID))
+}
+
+// checkScheduled validates a scheduled message as its sender saves it, so
+// most mistakes surface now rather than when it's due. On a bad message it
+// writes the error itself and reports false.
+func checkScheduled(c *gin.Context, client *dynamodb.Client, chat *services.Chat, s services.ScheduledMessage) bool {
+	now := time.Now()
+	switch {
+	case s.Content == "" && len(s.Media) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A message needs content or media"})
+		return false
+	case s.SendAt <= now.Unix():
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sendAt must be in the future"})
+		return false
+	case s.SendAt > now.Add(MaxScheduleAhead).Unix():
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sendAt is too far ahead"})
+		return false
+	}
+
+	if _, err := resolveMentions(chat, s.SenderID, s.Content, s.Mentions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if err := authorizeMedia(client, s.SenderID, chat.ID, s.Media); err != nil {
+		c.JSON(saveErrorStatus(err), gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// scheduledCreate is the body of a new scheduled message: a messageCreate
+// plus when to send it, in Unix seconds.
+type scheduledCreate struct {
+	Content  string   `json:"content"`
+	Media    []string `json:"media"`
+	ParentID string   `json:"parentId"`
+	QuoteID  string   `json:"quoteId"`
+	Mentions []string `json:"mentions"`
+	SendAt   int64    `json:"sendAt" binding:"required"`
+}
+
+// ScheduleMessage schedules a message from the caller to the chat.
+func ScheduleMessage(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req scheduledCreate
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		chat := currentChat(c)
+		now := time.Now().Unix()
+		s := services.ScheduledMessage{
+			ChatID:    chat.ID,
+			ID:        NewID("s"),
+			SenderID:  currentClaims(c).ID,
+			Content:   req.Content,
+			Media:     req.Media,
+			ParentID:  req.ParentID,
+			QuoteID:   req.QuoteID,
+			Mentions:  req.Mentions,
+			SendAt:    req.SendAt,
+			Status:    services.ScheduledPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if !checkScheduled(c, client, chat, s) {
+			return
+		}
+
+		if err := services.CreateScheduledMessage(client, "scheduled_messages", s); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Message scheduled successfully",
+			"data":    s,
+		})
+	}
+}
+
+// GetScheduledMessages lists the caller's scheduled messages in the chat
+// that haven't been sent yet, including any that failed but not those
+// being sent right now.
+func GetScheduledMessages(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheduled, err := services.GetUserScheduledMessages(client, "scheduled_messages", currentChat(c).ID, currentClaims(c).ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"scheduled": scheduled})
+	}
+}
+
+// scheduledEdit is the body of a scheduled message edit. Fields left out
+// keep their current value.
+type scheduledEdit struct {
+	Content  *string   `json:"content"`
+	Media    *[]string `json:"media"`
+	Mentions *[]string `json:"mentions"`
+	SendAt   *int64    `json:"sendAt"`
+}
+
+// UpdateScheduledMessage lets the sender change a scheduled message before
+// it's sent. Saving a failed one schedules it again.
+func UpdateScheduledMessage(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chat := currentChat(c)
+		s, ok := ownScheduled(c, client, chat.ID)
+		if !ok {
+			return
+		}
+
+		var req scheduledEdit
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if req.Content != nil {
+			s.Content = *req.Content
+		}
+		if req.Media != nil {
+			s.Media = *req.Media
+		}
+		if req.Mentions != nil {
+			s.Mentions = *req.Mentions
+		}
+		if req.SendAt != nil {
+			s.SendAt = *req.SendAt
+		}
+		if !checkScheduled(c, client, chat, *s) {
+			return
+		}
+
+		s.UpdatedAt = time.Now().Unix()
+		err := services.UpdateScheduledMessage(client, "scheduled_messages", *s)
+		if errors.Is(err, services.ErrScheduledNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		s.Status, s.Error = services.ScheduledPending, ""
+
+		c.JSON(http.StatusOK, gin.H{"message": "Scheduled message updated successfully", "data": s})
+	}
+}
+
+// CancelScheduledMessage deletes a scheduled message before it's sent.
+func CancelScheduledMessage(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s, ok := ownScheduled(c, client, currentChat(c).ID)
+		if !ok {
+			return
+		}
+
+		err := services.CancelScheduledMessage(client, "scheduled_messages", s.ChatID, s.ID)
+		if errors.Is(err, services.ErrScheduledNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Scheduled message cancelled successfully"})
+	}
+}
+
+// ownScheduled loads the scheduled message named in the path, which only
+// its sender may change. On failure it writes the error itself and
+// reports false.
+func ownScheduled(c *gin.Context, client *dynamodb.Client, chatID string) (*services.ScheduledMessage, bool) {
+	s, err := services.GetScheduledMessage(client, "scheduled_messages", chatID, c.Param("scheduledId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if s.SenderID != currentClaims(c).ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the sender can change this scheduled message"})
+		return nil, false
+	}
+	return s, true
+}
@@ -0,0 +1,236 @@
+package search
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deletedTTL is how long a MemoryIndex remembers a deleted document. Only
+// changes already in flight when it was deleted can bring it back, like a
+// backfill page read just before, so a few minutes is plenty.
+const deletedTTL = 5 * time.Minute
+
+// MemoryIndex is an embedded inverted index. It only knows the documents
+// indexed by this process, so every instance has to be fed every change.
+// Prefix terms are expanded by walking the whole vocabulary.
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*memoryDoc
+	postings map[string]map[string]struct{} // term -> IDs of documents containing it
+	deleted  map[string]time.Time           // ID -> when it was deleted, for deletedTTL
+	pruned   time.Time                      // when deleted was last swept
+}
+
+type memoryDoc struct {
+	Document
+	tokens []token
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		docs:     make(map[string]*memoryDoc),
+		postings: make(map[string]map[string]struct{}),
+		deleted:  make(map[string]time.Time),
+		pruned:   time.Now(),
+	}
+}
+
+func (m *MemoryIndex) Index(doc Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cur, ok := m.docs[doc.ID]; ok && cur.Version > doc.Version {
+		return nil
+	}
+	m.indexLocked(doc)
+	return nil
+}
+
+func (m *MemoryIndex) Backfill(doc Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cur, ok := m.docs[doc.ID]; ok && cur.Version >= doc.Version {
+		return nil
+	}
+	m.indexLocked(doc)
+	return nil
+}
+
+func (m *MemoryIndex) indexLocked(doc Document) {
+	if at, ok := m.deleted[doc.ID]; ok && time.Since(at) < deletedTTL {
+		return
+	}
+	delete(m.deleted, doc.ID)
+	m.deleteLocked(doc.ID)
+	d := &memoryDoc{Document: doc, tokens: tokenize(doc.Content)}
+	m.docs[doc.ID] = d
+	for _, t := range d.tokens {
+		ids, ok := m.postings[t.term]
+		if !ok {
+			ids = make(map[string]struct{})
+			m.postings[t.term] = ids
+		}
+		ids[doc.ID] = struct{}{}
+	}
+}
+
+func (m *MemoryIndex) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteLocked(id)
+
+	now := time.Now()
+	m.deleted[id] = now
+	if now.Sub(m.pruned) >= deletedTTL {
+		for id, at := range m.deleted {
+			if now.Sub(at) >= deletedTTL {
+				delete(m.deleted, id)
+			}
+		}
+		m.pruned = now
+	}
+	return nil
+}
+
+func (m *MemoryIndex) deleteLocked(id string) {
+	d, ok := m.docs[id]
+	if !ok {
+		return
+	}
+	delete(m.docs, id)
+	for _, t := range d.tokens {
+		if ids, ok := m.postings[t.term]; ok {
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(m.postings, t.term)
+			}
+		}
+	}
+}
+
+func (m *MemoryIndex) Search(q Query) (Result, error) {
+	clauses, err := parseQuery(q.Text)
+	if err != nil {
+		return Result{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var hits []Hit
+	for id := range m.candidatesLocked(clauses[0]) {
+		d := m.docs[id]
+		if !slices.Contains(q.ChatIDs, d.ChatID) || slices.Contains(d.HiddenFor, q.UserID) {
+			continue
+		}
+
+		var spans []span
+		for _, c := range clauses {
+			found := matchClause(d.tokens, c)
+			if len(found) == 0 {
+				spans = nil
+				break
+			}
+			spans = append(spans, found...)
+		}
+		if len(spans) == 0 {
+			continue
+		}
+
+		sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+		spans = mergeSpans(spans)
+		hits = append(hits, Hit{
+			ID:        d.ID,
+			ChatID:    d.ChatID,
+			ParentID:  d.ParentID,
+			SenderID:  d.SenderID,
+			Timestamp: d.Timestamp,
+			Snippet:   snippet(d.Content, spans),
+			Score:     float64(len(spans)) / float64(len(d.tokens)),
+		})
+	}
+
+	// best first, newest breaking ties
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].Timestamp != hits[j].Timestamp {
+			return hits[i].Timestamp > hits[j].Timestamp
+		}
+		return hits[i].ID > hits[j].ID
+	})
+
+	total := len(hits)
+	from := min(q.Offset, total)
+	to := total
+	if q.Limit > 0 {
+		to = min(from+q.Limit, total)
+	}
+	return Result{Hits: append([]Hit{}, hits[from:to]...), Total: total}, nil
+}
+
+func (m *MemoryIndex) Close() error {
+	return nil
+}
+
+// candidatesLocked returns the IDs of documents holding the first term of
+// c, or any term it's a prefix of.
+func (m *MemoryIndex) candidatesLocked(c clause) map[string]struct{} {
+	first := c.terms[0]
+	if !c.prefix || len(c.terms) > 1 {
+		return m.postings[first]
+	}
+
+	ids := make(map[string]struct{})
+	for term, docs := range m.postings {
+		if strings.HasPrefix(term, first) {
+			for id := range docs {
+				ids[id] = struct{}{}
+			}
+		}
+	}
+	return ids
+}
+
+// matchClause finds every run of tokens matching c.
+func matchClause(tokens []token, c clause) []span {
+	var spans []span
+	n := len(c.terms)
+	for i := 0; i+n <= len(tokens); i++ {
+		ok := true
+		for k, term := range c.terms {
+			got := tokens[i+k].term
+			if k == n-1 && c.prefix {
+				ok = strings.HasPrefix(got, term)
+			} else {
+				ok = got == term
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			spans = append(spans, span{start: tokens[i].start, end: tokens[i+n-1].end})
+		}
+	}
+	return spans
+}
+
+// mergeSpans joins overlapping spans of a sorted list.
+func mergeSpans(spans []span) []span {
+	out := spans[:1]
+	for _, s := range spans[1:] {
+		last := &out[len(out)-1]
+		if s.start <= last.end {
+			last.end = max(last.end, s.end)
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
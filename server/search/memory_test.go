@@ -0,0 +1,169 @@
+package search
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func searchIDs(t *testing.T, m *MemoryIndex, q Query) []string {
+	t.Helper()
+	res, err := m.Search(q)
+	if err != nil {
+		t.Fatalf("Search(%+v): %v", q, err)
+	}
+	ids := make([]string, 0, len(res.Hits))
+	for _, h := range res.Hits {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+func TestMemoryIndexSearch(t *testing.T) {
+	m := NewMemoryIndex()
+	docs := []Document{
+		{ID: "m1", ChatID: "c1", Content: "lunch at noon", Timestamp: 1},
+		{ID: "m2", ChatID: "c1", Content: "lunch", Timestamp: 2},
+		{ID: "m3", ChatID: "c1", Content: "lunch lunch and more", Timestamp: 3},
+		{ID: "m4", ChatID: "c1", Content: "late lunch", Timestamp: 4},
+		{ID: "m5", ChatID: "c2", Content: "lunch", Timestamp: 5},
+		{ID: "m6", ChatID: "c1", Content: "lunch", Timestamp: 6, HiddenFor: []string{"u1"}},
+		{ID: "m7", ChatID: "c1", Content: "Lunchtime at the e-mail desk", Timestamp: 7},
+	}
+	for _, d := range docs {
+		if err := m.Index(d); err != nil {
+			t.Fatalf("Index(%s): %v", d.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		// m2 is all match, m3 has half its tokens matching, m4 half but
+		// newer than m3, m1 a third
+		{"ranking", Query{Text: "lunch", ChatIDs: []string{"c1"}, UserID: "u1"}, []string{"m2", "m4", "m3", "m1"}},
+		{"hidden for someone else", Query{Text: "lunch", ChatIDs: []string{"c1"}, UserID: "u2"}, []string{"m6", "m2", "m4", "m3", "m1"}},
+		{"other chats", Query{Text: "lunch", ChatIDs: []string{"c2"}, UserID: "u1"}, []string{"m5"}},
+		{"no chats", Query{Text: "lunch", UserID: "u1"}, []string{}},
+		{"all clauses", Query{Text: "lunch noon", ChatIDs: []string{"c1"}, UserID: "u1"}, []string{"m1"}},
+		{"phrase", Query{Text: `"at noon"`, ChatIDs: []string{"c1"}, UserID: "u1"}, []string{"m1"}},
+		{"phrase out of order", Query{Text: `"noon at"`, ChatIDs: []string{"c1"}, UserID: "u1"}, []string{}},
+		{"prefix", Query{Text: "LUNCHT*", ChatIDs: []string{"c1"}, UserID: "u1"}, []string{"m7"}},
+		{"compound word", Query{Text: "e-mail", ChatIDs: []string{"c1"}, UserID: "u1"}, []string{"m7"}},
+		{"page", Query{Text: "lunch", ChatIDs: []string{"c1"}, UserID: "u1", Offset: 1, Limit: 2}, []string{"m4", "m3"}},
+		{"page past the end", Query{Text: "lunch", ChatIDs: []string{"c1"}, UserID: "u1", Offset: 10}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchIDs(t, m, tt.q); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	res, err := m.Search(Query{Text: "lunch", ChatIDs: []string{"c1"}, UserID: "u1", Limit: 1})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if res.Total != 4 {
+		t.Fatalf("Total = %d, want 4", res.Total)
+	}
+
+	if _, err := m.Search(Query{Text: "  ", ChatIDs: []string{"c1"}}); err != ErrEmptyQuery {
+		t.Fatalf("Search of blank text: %v, want ErrEmptyQuery", err)
+	}
+}
+
+func TestMemoryIndexVersions(t *testing.T) {
+	type op struct {
+		kind    string // index, backfill or delete
+		version int64
+		content string
+	}
+	tests := []struct {
+		name string
+		ops  []op
+		want string // content left in the index, "" for none
+	}{
+		{"edit replaces", []op{{"index", 1, "old"}, {"index", 2, "new"}}, "new"},
+		{"stale edit ignored", []op{{"index", 2, "new"}, {"index", 1, "old"}}, "new"},
+		{"same version reindexed", []op{{"index", 1, "old"}, {"index", 1, "new"}}, "new"},
+		{"backfill adds", []op{{"backfill", 1, "old"}}, "old"},
+		{"backfill behind edit", []op{{"index", 2, "new"}, {"backfill", 1, "old"}}, "new"},
+		{"backfill at same version", []op{{"index", 1, "new"}, {"backfill", 1, "old"}}, "new"},
+		{"backfill ahead", []op{{"index", 1, "old"}, {"backfill", 2, "new"}}, "new"},
+		{"delete", []op{{"index", 1, "old"}, {"delete", 0, ""}}, ""},
+		{"edit after delete", []op{{"index", 1, "old"}, {"delete", 0, ""}, {"index", 2, "new"}}, ""},
+		{"backfill after delete", []op{{"delete", 0, ""}, {"backfill", 1, "old"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemoryIndex()
+			for _, o := range tt.ops {
+				doc := Document{ID: "m1", ChatID: "c1", Content: o.content, Version: o.version}
+				var err error
+				switch o.kind {
+				case "index":
+					err = m.Index(doc)
+				case "backfill":
+					err = m.Backfill(doc)
+				case "delete":
+					err = m.Delete(doc.ID)
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", o.kind, err)
+				}
+			}
+
+			got := ""
+			if d, ok := m.docs["m1"]; ok {
+				got = d.Content
+			}
+			if got != tt.want {
+				t.Fatalf("content = %q, want %q", got, tt.want)
+			}
+			for _, word := range []string{"old", "new"} {
+				ids := searchIDs(t, m, Query{Text: word, ChatIDs: []string{"c1"}})
+				if want := word == tt.want; want != (len(ids) == 1) {
+					t.Fatalf("search for %q found %v", word, ids)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryIndexForgetsDeletes(t *testing.T) {
+	m := NewMemoryIndex()
+	if err := m.Delete("m1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	// once the tombstone is past deletedTTL the ID can be indexed again
+	m.deleted["m1"] = time.Now().Add(-deletedTTL)
+	if err := m.Index(Document{ID: "m1", ChatID: "c1", Content: "back"}); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if _, ok := m.docs["m1"]; !ok {
+		t.Fatal("m1 not indexed after its tombstone expired")
+	}
+	if _, ok := m.deleted["m1"]; ok {
+		t.Fatal("expired tombstone for m1 kept")
+	}
+
+	// a delete after deletedTTL sweeps out the expired tombstones
+	m.deleted["old"] = time.Now().Add(-deletedTTL)
+	m.pruned = time.Now().Add(-deletedTTL)
+	if err := m.Delete("m2"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := m.deleted["old"]; ok {
+		t.Fatal("expired tombstone not swept")
+	}
+	if _, ok := m.deleted["m2"]; !ok {
+		t.Fatal("fresh tombstone swept")
+	}
+}
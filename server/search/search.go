@@ -0,0 +1,204 @@
+package search
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrEmptyQuery is returned for a query with nothing to match.
+var ErrEmptyQuery = errors.New("search query has no terms")
+
+// Document is a message as the index sees it.
+type Document struct {
+	ID        string   `json:"id"`
+	ChatID    string   `json:"chatId"`
+	ParentID  string   `json:"parentId,omitempty"`
+	SenderID  string   `json:"senderId"`
+	Content   string   `json:"content"`
+	Timestamp int64    `json:"timestamp"`
+	Version   int64    `json:"version"`             // when the content was last set; edits raise it
+	HiddenFor []string `json:"hiddenFor,omitempty"` // users who deleted it for themselves
+}
+
+// Query asks for documents in ChatIDs matching Text that UserID may see.
+type Query struct {
+	Text    string
+	ChatIDs []string
+	UserID  string
+	Offset  int
+	Limit   int
+}
+
+// Hit is one matching document with a snippet of its content, HTML-escaped
+// with each match wrapped in <mark></mark>.
+type Hit struct {
+	ID        string  `json:"id"`
+	ChatID    string  `json:"chatId"`
+	ParentID  string  `json:"parentId,omitempty"`
+	SenderID  string  `json:"senderId"`
+	Timestamp int64   `json:"timestamp"`
+	Snippet   string  `json:"snippet"`
+	Score     float64 `json:"score"`
+}
+
+// Result is one page of hits, best first, out of Total matches.
+type Result struct {
+	Hits  []Hit `json:"hits"`
+	Total int   `json:"total"`
+}
+
+// Index is a full-text index of message content. Implementations must be
+// safe for concurrent use.
+type Index interface {
+	// Index adds doc or replaces the document with its ID, unless the
+	// index holds a newer version of it or it was deleted.
+	Index(doc Document) error
+	// Backfill adds doc unless the index already holds it at this version
+	// or later, or it was deleted, so filling the index from a snapshot of
+	// the messages can't undo changes that arrived while it was taken.
+	Backfill(doc Document) error
+	// Delete removes the document with id. Versions of it that were in
+	// flight when it went are ignored.
+	Delete(id string) error
+	Search(q Query) (Result, error)
+	Close() error
+}
+
+// clause is one part of a query. Its terms must appear consecutively in a
+// document; when prefix is set the last one only has to start the word.
+type clause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseQuery splits a query into clauses, all of which must match. Quoted
+// text is a phrase and any other word stands alone, though a word that
+// tokenizes into several terms, like "e-mail", is matched as a phrase. A
+// trailing * turns the last term into a prefix.
+func parseQuery(text string) ([]clause, error) {
+	var clauses []clause
+	add := func(part string) {
+		prefix := strings.HasSuffix(part, "*")
+		var terms []string
+		for _, t := range tokenize(part) {
+			terms = append(terms, t.term)
+		}
+		if len(terms) > 0 {
+			clauses = append(clauses, clause{terms: terms, prefix: prefix})
+		}
+	}
+
+	for text != "" {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if text == "" {
+			break
+		}
+		if text[0] == '"' {
+			phrase, rest, _ := strings.Cut(text[1:], `"`)
+			add(phrase)
+			text = rest
+			continue
+		}
+		end := strings.IndexFunc(text, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end < 0 {
+			end = len(text)
+		}
+		add(text[:end])
+		text = text[end:]
+	}
+
+	if len(clauses) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	return clauses, nil
+}
+
+// token is a lowercased word and where it sits in the original text.
+type token struct {
+	term       string
+	start, end int // byte offsets
+}
+
+// tokenize splits text into runs of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// span is a match in a document's content, as byte offsets.
+type span struct{ start, end int }
+
+const (
+	snippetLead  = 40  // bytes of context kept before the first match
+	snippetWidth = 200 // bytes a snippet spans at most
+)
+
+// snippet cuts the stretch of content around the first match and marks
+// every match inside it. spans must be sorted and not overlap.
+func snippet(content string, spans []span) string {
+	if len(spans) == 0 {
+		return html.EscapeString(truncate(content, 0, snippetWidth))
+	}
+
+	from := max(0, spans[0].start-snippetLead)
+	for from > 0 && !utf8.RuneStart(content[from]) {
+		from--
+	}
+	// start on a word boundary when there's one close by
+	if from > 0 {
+		if i := strings.IndexFunc(content[from:spans[0].start], unicode.IsSpace); i >= 0 {
+			from += i + 1
+		}
+	}
+	to := min(len(content), from+snippetWidth)
+	for to < len(content) && !utf8.RuneStart(content[to]) {
+		to--
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	at := from
+	for _, s := range spans {
+		if s.start < at || s.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(content[at:s.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(content[s.start:s.end]))
+		b.WriteString("</mark>")
+		at = s.end
+	}
+	b.WriteString(html.EscapeString(content[at:to]))
+	if to < len(content) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// truncate returns content[from:] cut to at most width bytes on a rune boundary.
+func truncate(content string, from, width int) string {
+	to := min(len(content), from+width)
+	for to < len(content) && !utf8.RuneStart(content[to]) {
+		to--
+	}
+	return content[from:to]
+}
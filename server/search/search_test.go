@@ -0,0 +1,77 @@
+package search
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []clause
+		err  error
+	}{
+		{"empty", "", nil, ErrEmptyQuery},
+		{"whitespace", " \t\n ", nil, ErrEmptyQuery},
+		{"punctuation only", `!? -- "" *`, nil, ErrEmptyQuery},
+		{"single word", "Hello", []clause{{terms: []string{"hello"}}}, nil},
+		{"words", "hello  world", []clause{{terms: []string{"hello"}}, {terms: []string{"world"}}}, nil},
+		{"prefix", "wor*", []clause{{terms: []string{"wor"}, prefix: true}}, nil},
+		{"lone star", "hello *", []clause{{terms: []string{"hello"}}}, nil},
+		{"phrase", `"Hello World"`, []clause{{terms: []string{"hello", "world"}}}, nil},
+		{"phrase prefix", `"hello wor*"`, []clause{{terms: []string{"hello", "wor"}, prefix: true}}, nil},
+		{"phrase and word", `say "hello world" twice`, []clause{
+			{terms: []string{"say"}},
+			{terms: []string{"hello", "world"}},
+			{terms: []string{"twice"}},
+		}, nil},
+		{"quote inside word", `say"hello world"`, []clause{
+			{terms: []string{"say"}},
+			{terms: []string{"hello", "world"}},
+		}, nil},
+		{"unterminated phrase", `"hello world`, []clause{{terms: []string{"hello", "world"}}}, nil},
+		{"compound word", "e-mail", []clause{{terms: []string{"e", "mail"}}}, nil},
+		{"unicode", "Ünïcode 東京", []clause{{terms: []string{"ünïcode"}}, {terms: []string{"東京"}}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQuery(tt.text)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseQuery(%q) error = %v, want %v", tt.text, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseQuery(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := "aaaa " + strings.Repeat("word ", 60)
+	head := strings.Repeat("x ", 50) + "needle"
+
+	tests := []struct {
+		name    string
+		content string
+		spans   []span
+		want    string
+	}{
+		{"no spans", "a <b> & c", nil, "a &lt;b&gt; &amp; c"},
+		{"marks and escapes", "<hi> there", []span{{5, 10}}, "&lt;hi&gt; <mark>there</mark>"},
+		{"several marks", "one two one", []span{{0, 3}, {8, 11}}, "<mark>one</mark> two <mark>one</mark>"},
+		{"cuts the tail", long, []span{{0, 4}}, "<mark>aaaa</mark>" + long[4:200] + "…"},
+		{"cuts the head on a space", head, []span{{100, 106}}, "…" + head[62:100] + "<mark>needle</mark>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snippet(tt.content, tt.spans); got != tt.want {
+				t.Fatalf("snippet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,31 +1,41 @@
 package server
 
 import (
-	"encoding/json"
+	"expvar"
+	"fluffy-coto-tribble/server/authentication"
 	"fluffy-coto-tribble/server/services"
 	"log"
-	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
 )
 
 func InitServer() {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
-	hub := newHub()
+	// connect DynamoDB
+	dynamoClient := services.ConnectDB()
+
+	// pick the search index before the hub starts feeding it changes
+	LoadSearchIndex(dynamoClient)
+
+	LoadWSConfig()
+	hub := newHub(dynamoClient, NewHubBroker())
 	go hub.run()
 
+	LoadPurgeConfig()
+	go purgeDeletedMessages(dynamoClient, hub)
+
+	LoadScheduleConfig()
+	go sendScheduledMessages(dynamoClient, hub)
+
+	AddDynamoDBRoutes(dynamoClient, hub, router)
+
 	// WebSocket
 	router.GET("/ws", func(c *gin.Context) {
 		serveWs(hub, c)
 	})
-
-	// connect DynamoDB
-	dynamoClient := services.ConnectDB()
-	AddDynamoDBRoutes(dynamoClient, router)
+	router.GET("/debug/vars", authentication.AuthMiddleware(), authentication.RequireRole(services.RoleAdmin), gin.WrapH(expvar.Handler()))
 
 	// connect S3
 	s3Client := services.ConnectS3()
@@ -38,121 +48,3 @@ func InitServer() {
 	log.Println("Server listening on :8080")
 	router.Run(":8080")
 }
-
-type WSMessage struct {
-	Event     string `json:"event"`
-	Message   string `json:"message"`
-	Timestamp int64  `json:"timestamp"`
-}
-
-type Client struct {
-	conn *websocket.Conn
-	send chan []byte
-}
-
-type Hub struct {
-	clients    map[*Client]bool
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan []byte
-}
-
-func newHub() *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan []byte),
-	}
-}
-
-func (h *Hub) run() {
-	log.Println("WebSocket server listening on /ws")
-
-	for {
-		select {
-		case client := <-h.register:
-			h.clients[client] = true
-			log.Println("Client connected")
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				log.Println("Client disconnected")
-			}
-		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
-		}
-	}
-}
-
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// allow all origins TESTING ONLY
-		return true
-	},
-}
-
-func serveWs(hub *Hub, c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		log.Println("Upgrade error:", err)
-		return
-	}
-	client := &Client{conn: conn, send: make(chan []byte, 256)}
-
-	hub.register <- client
-
-	go client.write()
-	go client.read(hub)
-}
-
-func (c *Client) read(hub *Hub) {
-	defer func() {
-		hub.unregister <- c
-		c.conn.Close()
-	}()
-	for {
-		_, msg, err := c.conn.ReadMessage()
-		if err != nil {
-			log.Println("Read error:", err)
-			break
-		}
-
-		var incoming WSMessage
-		if err := json.Unmarshal(msg, &incoming); err != nil {
-			log.Println("Invalid JSON message:", err)
-			continue
-		}
-
-		log.Printf("{ Event: %s, Message: %s, Timestamp: %d }\n",
-			incoming.Event, incoming.Message, incoming.Timestamp)
-
-		outgoing := WSMessage{
-			Event:     incoming.Event + "_ack",
-			Message:   incoming.Message,
-			Timestamp: time.Now().UnixMilli(),
-		}
-		outBytes, _ := json.Marshal(outgoing)
-
-		hub.broadcast <- outBytes
-	}
-}
-
-func (c *Client) write() {
-	defer c.conn.Close()
-	for msg := range c.send {
-		err := c.conn.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			log.Println("Write error:", err)
-			break
-		}
-	}
-}
@@ -2,7 +2,10 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -48,21 +51,36 @@ func CreateChat(client *dynamodb.Client, tableName string, chat Chat) error {
 }
 
 func GetAllChats(client *dynamodb.Client, tableName string) ([]Chat, error) {
-	out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to scan chats: %w", err)
-	}
-
 	var chats []Chat
-	err = attributevalue.UnmarshalListOfMaps(out.Items, &chats)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal chats: %w", err)
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
+			TableName:         aws.String(tableName),
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan chats: %w", err)
+		}
+
+		var page []Chat
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal chats: %w", err)
+		}
+		chats = append(chats, page...)
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
 	}
+
 	return chats, nil
 }
 
+// ErrChatNotFound is returned when a chat doesn't exist.
+var ErrChatNotFound = errors.New("chat not found")
+
 func GetChatById(client *dynamodb.Client, tableName, chatID string) (*Chat, error) {
 	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
@@ -74,7 +92,7 @@ func GetChatById(client *dynamodb.Client, tableName, chatID string) (*Chat, erro
 		return nil, fmt.Errorf("failed to get chat: %w", err)
 	}
 	if out.Item == nil {
-		return nil, fmt.Errorf("chat not found")
+		return nil, ErrChatNotFound
 	}
 
 	var chat Chat
@@ -85,19 +103,26 @@ func GetChatById(client *dynamodb.Client, tableName, chatID string) (*Chat, erro
 	return &chat, nil
 }
 
+// ErrChatChanged is returned when a chat's members changed since it was read.
+var ErrChatChanged = errors.New("chat changed since it was read")
+
+// UpdateChat sets the given attributes of a chat. Attribute names are bound
+// through ExpressionAttributeNames, never spliced into the expression, but
+// callers still decide which attributes may be written.
 func UpdateChat(client *dynamodb.Client, tableName, chatID string, updates map[string]types.AttributeValue) error {
-	updateExpr := "SET"
-	exprAttrValues := map[string]types.AttributeValue{}
-	i := 0
+	names := make([]string, 0, len(updates))
+	for name := range updates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	for k, v := range updates {
-		if i > 0 {
-			updateExpr += ","
-		}
-		placeholder := fmt.Sprintf(":%s", k)
-		updateExpr += fmt.Sprintf(" %s = %s", k, placeholder)
-		exprAttrValues[placeholder] = v
-		i++
+	sets := make([]string, 0, len(names))
+	exprAttrNames := map[string]string{}
+	exprAttrValues := map[string]types.AttributeValue{}
+	for i, name := range names {
+		sets = append(sets, fmt.Sprintf("#f%d = :v%d", i, i))
+		exprAttrNames[fmt.Sprintf("#f%d", i)] = name
+		exprAttrValues[fmt.Sprintf(":v%d", i)] = updates[name]
 	}
 
 	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
@@ -105,9 +130,10 @@ func UpdateChat(client *dynamodb.Client, tableName, chatID string, updates map[s
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: chatID},
 		},
-		UpdateExpression:          aws.String(updateExpr),
+		ConditionExpression:       aws.String("attribute_exists(id)"),
+		UpdateExpression:          aws.String("SET " + strings.Join(sets, ", ")),
+		ExpressionAttributeNames:  exprAttrNames,
 		ExpressionAttributeValues: exprAttrValues,
-		ReturnValues:              types.ReturnValueUpdatedNew,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to update chat: %w", err)
@@ -115,6 +141,40 @@ func UpdateChat(client *dynamodb.Client, tableName, chatID string, updates map[s
 	return nil
 }
 
+// SetChatUsers replaces a chat's member list, provided the chat hasn't been
+// updated since dateUpdated, and returns ErrChatChanged if it has.
+func SetChatUsers(client *dynamodb.Client, tableName, chatID string, users []string, dateUpdated, now int64) error {
+	av, err := attributevalue.Marshal(users)
+	if err != nil {
+		return fmt.Errorf("failed to marshal users: %w", err)
+	}
+
+	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: chatID},
+		},
+		ConditionExpression: aws.String("dateUpdated = :prev"),
+		UpdateExpression:    aws.String("SET #users = :users, dateUpdated = :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#users": "users",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":users": av,
+			":prev":  &types.AttributeValueMemberN{Value: fmt.Sprint(dateUpdated)},
+			":now":   &types.AttributeValueMemberN{Value: fmt.Sprint(now)},
+		},
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return ErrChatChanged
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update chat members: %w", err)
+	}
+	return nil
+}
+
 func DeleteChat(client *dynamodb.Client, tableName, chatID string) error {
 	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
 		TableName: aws.String(tableName),
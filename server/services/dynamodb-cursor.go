@@ -0,0 +1,63 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// EncodeCursor turns a LastEvaluatedKey into an opaque token clients can
+// hand back to fetch the next page. An empty key encodes to "".
+func EncodeCursor(key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+
+	plain := make(map[string]map[string]string, len(key))
+	for name, v := range key {
+		switch av := v.(type) {
+		case *types.AttributeValueMemberS:
+			plain[name] = map[string]string{"S": av.Value}
+		case *types.AttributeValueMemberN:
+			plain[name] = map[string]string{"N": av.Value}
+		default:
+			return "", fmt.Errorf("unsupported cursor attribute %s", name)
+		}
+	}
+
+	raw, err := json.Marshal(plain)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// DecodeCursor reverses EncodeCursor. An empty token decodes to a nil key.
+func DecodeCursor(cursor string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var plain map[string]map[string]string
+	if err := json.Unmarshal(raw, &plain); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	key := make(map[string]types.AttributeValue, len(plain))
+	for name, v := range plain {
+		if s, ok := v["S"]; ok {
+			key[name] = &types.AttributeValueMemberS{Value: s}
+		} else if n, ok := v["N"]; ok {
+			key[name] = &types.AttributeValueMemberN{Value: n}
+		} else {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrLockHeld is returned when taking a lock someone else holds.
+var ErrLockHeld = errors.New("lock is held by another owner")
+
+// CreateLocksTable creates the table of leases that let one instance at a
+// time run a job.
+func CreateLocksTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("name"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("name"),
+				KeyType:       types.KeyTypeHash, // Partition Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	return err
+}
+
+// AcquireLock takes the named lock for owner until expiresAt, or extends it
+// if owner already holds it. It returns ErrLockHeld while another owner's
+// lease is still running at now.
+func AcquireLock(client *dynamodb.Client, tableName, name, owner string, now, expiresAt int64) error {
+	_, err := client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item: map[string]types.AttributeValue{
+			"name":      &types.AttributeValueMemberS{Value: name},
+			"owner":     &types.AttributeValueMemberS{Value: owner},
+			"expiresAt": &types.AttributeValueMemberN{Value: fmt.Sprint(expiresAt)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#n) OR #o = :o OR expiresAt <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#n": "name",
+			"#o": "owner",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":o":   &types.AttributeValueMemberS{Value: owner},
+			":now": &types.AttributeValueMemberN{Value: fmt.Sprint(now)},
+		},
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return ErrLockHeld
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// CreateChatMembersTable creates the user -> chat membership index, with a
+// local index ordering each user's chats by last activity, then fills it
+// from any chats that already exist.
+func CreateChatMembersTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("userId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("chatId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("lastActivity"),
+				AttributeType: types.ScalarAttributeTypeN,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("userId"),
+				KeyType:       types.KeyTypeHash, // Partition Key
+			},
+			{
+				AttributeName: aws.String("chatId"),
+				KeyType:       types.KeyTypeRange, // Sort Key
+			},
+		},
+		LocalSecondaryIndexes: []types.LocalSecondaryIndex{
+			{
+				IndexName: aws.String("lastActivity-index"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("userId"),
+						KeyType:       types.KeyTypeHash,
+					},
+					{
+						AttributeName: aws.String("lastActivity"),
+						KeyType:       types.KeyTypeRange,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return err
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	if err := waiter.Wait(context.TODO(), &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	}, 2*time.Minute); err != nil {
+		return fmt.Errorf("waiting for %s table: %w", tableName, err)
+	}
+
+	return backfillChatMembers(client, tableName)
+}
+
+// backfillChatMembers indexes chats created before the membership table existed.
+func backfillChatMembers(client *dynamodb.Client, tableName string) error {
+	chats, err := GetAllChats(client, "chats")
+	var notFoundErr *types.ResourceNotFoundException
+	if errors.As(err, &notFoundErr) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, chat := range chats {
+		if err := AddChatMembers(client, tableName, chat.ID, chat.Users, chat.DateUpdated); err != nil {
+			return err
+		}
+	}
+	log.Printf("Indexed members of %d existing chats\n", len(chats))
+	return nil
+}
+
+// AddChatMembers records each user as a member of the chat.
+func AddChatMembers(client *dynamodb.Client, tableName, chatID string, userIDs []string, lastActivity int64) error {
+	requests := make([]types.WriteRequest, 0, len(userIDs))
+	for _, userID := range userIDs {
+		item, err := attributevalue.MarshalMap(ChatMember{
+			UserID:       userID,
+			ChatID:       chatID,
+			LastActivity: lastActivity,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal chat member: %w", err)
+		}
+		requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+	return batchWrite(client, tableName, requests)
+}
+
+// RemoveChatMembers drops each user's membership of the chat.
+func RemoveChatMembers(client *dynamodb.Client, tableName, chatID string, userIDs []string) error {
+	requests := make([]types.WriteRequest, 0, len(userIDs))
+	for _, userID := range userIDs {
+		requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{
+			Key: map[string]types.AttributeValue{
+				"userId": &types.AttributeValueMemberS{Value: userID},
+				"chatId": &types.AttributeValueMemberS{Value: chatID},
+			},
+		}})
+	}
+	return batchWrite(client, tableName, requests)
+}
+
+// TouchChatMembers bumps the chat's last activity for each member so it
+// sorts to the top of their chat list. It never moves activity backwards.
+func TouchChatMembers(client *dynamodb.Client, tableName, chatID string, userIDs []string, lastActivity int64) error {
+	for _, userID := range userIDs {
+		_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"userId": &types.AttributeValueMemberS{Value: userID},
+				"chatId": &types.AttributeValueMemberS{Value: chatID},
+			},
+			UpdateExpression:    aws.String("SET lastActivity = :t"),
+			ConditionExpression: aws.String("attribute_exists(chatId) AND lastActivity < :t"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":t": &types.AttributeValueMemberN{Value: fmt.Sprint(lastActivity)},
+			},
+		})
+		var condErr *types.ConditionalCheckFailedException
+		if err != nil && !errors.As(err, &condErr) {
+			return fmt.Errorf("failed to update chat activity: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetUserChatPage returns up to limit of the user's memberships, most
+// recently active first, starting after startKey. The returned key is nil
+// on the last page.
+func GetUserChatPage(client *dynamodb.Client, tableName, userID string, limit int32, startKey map[string]types.AttributeValue) ([]ChatMember, map[string]types.AttributeValue, error) {
+	out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String("lastActivity-index"),
+		KeyConditionExpression: aws.String("userId = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: userID},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: startKey,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query chat members: %w", err)
+	}
+
+	var members []ChatMember
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &members); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal chat members: %w", err)
+	}
+	return members, out.LastEvaluatedKey, nil
+}
+
+// GetUserChatIDs returns the IDs of every chat the user belongs to.
+func GetUserChatIDs(client *dynamodb.Client, tableName, userID string) ([]string, error) {
+	var ids []string
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			KeyConditionExpression: aws.String("userId = :u"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":u": &types.AttributeValueMemberS{Value: userID},
+			},
+			ProjectionExpression: aws.String("chatId"),
+			ExclusiveStartKey:    lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query chat members: %w", err)
+		}
+
+		for _, item := range out.Items {
+			if id, ok := item["chatId"].(*types.AttributeValueMemberS); ok {
+				ids = append(ids, id.Value)
+			}
+		}
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return ids, nil
+}
+
+// GetChatsByIds loads chats in the order given, skipping any that no
+// longer exist.
+func GetChatsByIds(client *dynamodb.Client, tableName string, ids []string) ([]Chat, error) {
+	byID := make(map[string]Chat, len(ids))
+
+	for start := 0; start < len(ids); start += 100 {
+		end := min(start+100, len(ids))
+
+		keys := make([]map[string]types.AttributeValue, 0, end-start)
+		for _, id := range ids[start:end] {
+			keys = append(keys, map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			})
+		}
+
+		request := map[string]types.KeysAndAttributes{tableName: {Keys: keys}}
+		for len(request) > 0 {
+			out, err := client.BatchGetItem(context.TODO(), &dynamodb.BatchGetItemInput{
+				RequestItems: request,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get chats: %w", err)
+			}
+
+			var chats []Chat
+			if err := attributevalue.UnmarshalListOfMaps(out.Responses[tableName], &chats); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal chats: %w", err)
+			}
+			for _, chat := range chats {
+				byID[chat.ID] = chat
+			}
+			request = out.UnprocessedKeys
+		}
+	}
+
+	chats := make([]Chat, 0, len(byID))
+	for _, id := range ids {
+		if chat, ok := byID[id]; ok {
+			chats = append(chats, chat)
+		}
+	}
+	return chats, nil
+}
+
+// batchWrite sends write requests 25 at a time, retrying anything DynamoDB
+// hands back as unprocessed.
+func batchWrite(client *dynamodb.Client, tableName string, requests []types.WriteRequest) error {
+	for start := 0; start < len(requests); start += 25 {
+		end := min(start+25, len(requests))
+
+		pending := map[string][]types.WriteRequest{tableName: requests[start:end]}
+		for len(pending) > 0 {
+			out, err := client.BatchWriteItem(context.TODO(), &dynamodb.BatchWriteItemInput{
+				RequestItems: pending,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to write %s: %w", tableName, err)
+			}
+			pending = out.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// IsChatMember reports whether the membership index lists the user in the chat.
+func IsChatMember(client *dynamodb.Client, tableName, userID, chatID string) (bool, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userID},
+			"chatId": &types.AttributeValueMemberS{Value: chatID},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get chat member: %w", err)
+	}
+	return out.Item != nil, nil
+}
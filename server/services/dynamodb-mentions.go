@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// CreateMentionsTable creates each user's mentions inbox. Message IDs sort
+// by time, so the sort key keeps an inbox in order.
+func CreateMentionsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("userId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("messageId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("userId"),
+				KeyType:       types.KeyTypeHash, // Partition Key
+			},
+			{
+				AttributeName: aws.String("messageId"),
+				KeyType:       types.KeyTypeRange, // Sort Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	return err
+}
+
+// AddMentions puts each mention in its user's inbox.
+func AddMentions(client *dynamodb.Client, tableName string, mentions []Mention) error {
+	requests := make([]types.WriteRequest, 0, len(mentions))
+	for _, m := range mentions {
+		item, err := attributevalue.MarshalMap(m)
+		if err != nil {
+			return fmt.Errorf("failed to marshal mention: %w", err)
+		}
+		requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+	return batchWrite(client, tableName, requests)
+}
+
+// RemoveMentions takes a message out of each user's inbox.
+func RemoveMentions(client *dynamodb.Client, tableName, messageID string, userIDs []string) error {
+	requests := make([]types.WriteRequest, 0, len(userIDs))
+	for _, userID := range userIDs {
+		requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{
+			Key: map[string]types.AttributeValue{
+				"userId":    &types.AttributeValueMemberS{Value: userID},
+				"messageId": &types.AttributeValueMemberS{Value: messageID},
+			},
+		}})
+	}
+	return batchWrite(client, tableName, requests)
+}
+
+// GetMentionPage returns up to limit of the user's mentions, newest first,
+// starting after startKey. The returned key is nil on the last page.
+func GetMentionPage(client *dynamodb.Client, tableName, userID string, limit int32, startKey map[string]types.AttributeValue) ([]Mention, map[string]types.AttributeValue, error) {
+	out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("userId = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: userID},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: startKey,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query mentions: %w", err)
+	}
+
+	var mentions []Mention
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &mentions); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal mentions: %w", err)
+	}
+	return mentions, out.LastEvaluatedKey, nil
+}
+
+// SetMessageMentions replaces the list of users a message mentions.
+func SetMessageMentions(client *dynamodb.Client, tableName, chatID, msgID string, userIDs []string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"chatId": &types.AttributeValueMemberS{Value: chatID},
+			"id":     &types.AttributeValueMemberS{Value: msgID},
+		},
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		UpdateExpression:    aws.String("REMOVE mentions"),
+	}
+	if len(userIDs) > 0 {
+		input.UpdateExpression = aws.String("SET mentions = :m")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":m": &types.AttributeValueMemberSS{Value: userIDs},
+		}
+	}
+
+	if _, err := client.UpdateItem(context.TODO(), input); err != nil {
+		return fmt.Errorf("failed to update mentions: %w", err)
+	}
+	return nil
+}
@@ -2,7 +2,11 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"slices"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -10,19 +14,103 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
-func CreateMessagesTable(client *dynamodb.Client, tableName string) error {
-	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
-		TableName: aws.String(tableName),
-		AttributeDefinitions: []types.AttributeDefinition{
+// messagesTimeIndex orders each chat's messages by timestamp, which covers
+// messages stored before IDs became ULIDs, so history pages are read from
+// here. Timestamps are in seconds and the index leaves messages sharing one
+// in no particular order, so pages break those ties by ID themselves.
+const messagesTimeIndex = "chatId-timestamp-index"
+
+// messagesThreadIndex orders the replies to each parent message by
+// timestamp. Only replies carry parentId, so the index stays sparse.
+const messagesThreadIndex = "parentId-timestamp-index"
+
+// messagesTombstoneIndex orders messages deleted for everyone by when they
+// were deleted, so the purge reads only expired tombstones. Only tombstones
+// carry the tombstone attribute, always tombstoneMark, so the index stays
+// sparse; they're purged after a while, so one partition holds them all.
+const messagesTombstoneIndex = "tombstone-deletedAt-index"
+
+const tombstoneMark = "deleted"
+
+// messageIndexKey is the key of a secondary index of the messages table:
+// a string partition key and a number sort key.
+type messageIndexKey struct {
+	hash, sort string
+}
+
+// messageIndexes are the secondary indexes of the messages table, in the
+// order they were added.
+var messageIndexes = []string{messagesTimeIndex, messagesThreadIndex, messagesTombstoneIndex}
+
+var messageIndexKeys = map[string]messageIndexKey{
+	messagesTimeIndex:      {hash: "chatId", sort: "timestamp"},
+	messagesThreadIndex:    {hash: "parentId", sort: "timestamp"},
+	messagesTombstoneIndex: {hash: "tombstone", sort: "deletedAt"},
+}
+
+func messageIndexSpec(name string) types.GlobalSecondaryIndex {
+	key := messageIndexKeys[name]
+	return types.GlobalSecondaryIndex{
+		IndexName: aws.String(name),
+		KeySchema: []types.KeySchemaElement{
 			{
-				AttributeName: aws.String("chatId"),
-				AttributeType: types.ScalarAttributeTypeS,
+				AttributeName: aws.String(key.hash),
+				KeyType:       types.KeyTypeHash,
 			},
 			{
-				AttributeName: aws.String("id"),
-				AttributeType: types.ScalarAttributeTypeS,
+				AttributeName: aws.String(key.sort),
+				KeyType:       types.KeyTypeRange,
 			},
 		},
+		Projection: &types.Projection{
+			ProjectionType: types.ProjectionTypeAll,
+		},
+	}
+}
+
+// messageIndexAttributes defines the key attributes of the named indexes.
+func messageIndexAttributes(names ...string) []types.AttributeDefinition {
+	var defs []types.AttributeDefinition
+	seen := map[string]bool{}
+	for _, name := range names {
+		key := messageIndexKeys[name]
+		for attr, kind := range map[string]types.ScalarAttributeType{key.hash: types.ScalarAttributeTypeS, key.sort: types.ScalarAttributeTypeN} {
+			if !seen[attr] {
+				seen[attr] = true
+				defs = append(defs, types.AttributeDefinition{AttributeName: aws.String(attr), AttributeType: kind})
+			}
+		}
+	}
+	return defs
+}
+
+// ErrMessageDeleted is returned when deleting a message that's already gone.
+var ErrMessageDeleted = errors.New("message has been deleted")
+
+func CreateMessagesTable(client *dynamodb.Client, tableName string) error {
+	attributes := []types.AttributeDefinition{
+		{
+			AttributeName: aws.String("chatId"),
+			AttributeType: types.ScalarAttributeTypeS,
+		},
+		{
+			AttributeName: aws.String("id"),
+			AttributeType: types.ScalarAttributeTypeS,
+		},
+	}
+	indexes := make([]types.GlobalSecondaryIndex, 0, len(messageIndexes))
+	for _, name := range messageIndexes {
+		indexes = append(indexes, messageIndexSpec(name))
+	}
+	for _, def := range messageIndexAttributes(messageIndexes...) {
+		if aws.ToString(def.AttributeName) != "chatId" {
+			attributes = append(attributes, def)
+		}
+	}
+
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName:            aws.String(tableName),
+		AttributeDefinitions: attributes,
 		KeySchema: []types.KeySchemaElement{
 			{
 				AttributeName: aws.String("chatId"),
@@ -33,11 +121,125 @@ func CreateMessagesTable(client *dynamodb.Client, tableName string) error {
 				KeyType:       types.KeyTypeRange, // Sort Key
 			},
 		},
-		BillingMode: types.BillingModePayPerRequest,
+		GlobalSecondaryIndexes: indexes,
+		BillingMode:            types.BillingModePayPerRequest,
 	})
 	return err
 }
 
+// messageIndexPoll is how often EnsureMessageIndexes checks on an index
+// being built.
+const messageIndexPoll = 5 * time.Second
+
+// EnsureMessageIndexes adds any secondary index missing from a messages
+// table created before it existed, and waits until every one is active so
+// nothing reads an index that isn't there yet. DynamoDB builds one new
+// index at a time, so they're added one after another; on a large table
+// this holds up startup until they're all built.
+func EnsureMessageIndexes(client *dynamodb.Client, tableName string) error {
+	for _, name := range messageIndexes {
+		built, err := ensureMessageIndex(client, tableName, name)
+		if err != nil {
+			return err
+		}
+		if built && name == messagesTombstoneIndex {
+			if err := markTombstones(client, tableName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureMessageIndex adds the named index unless it exists and waits for
+// it to become active. built reports whether it wasn't active yet, which
+// covers a build started by an earlier start that didn't see it through.
+func ensureMessageIndex(client *dynamodb.Client, tableName, name string) (built bool, err error) {
+	for {
+		out, err := client.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return built, fmt.Errorf("error checking %s table: %w", tableName, err)
+		}
+
+		i := slices.IndexFunc(out.Table.GlobalSecondaryIndexes, func(gsi types.GlobalSecondaryIndexDescription) bool {
+			return aws.ToString(gsi.IndexName) == name
+		})
+		if i >= 0 {
+			if out.Table.GlobalSecondaryIndexes[i].IndexStatus == types.IndexStatusActive {
+				return built, nil
+			}
+			built = true
+			time.Sleep(messageIndexPoll)
+			continue
+		}
+
+		spec := messageIndexSpec(name)
+		_, err = client.UpdateTable(context.TODO(), &dynamodb.UpdateTableInput{
+			TableName:            aws.String(tableName),
+			AttributeDefinitions: messageIndexAttributes(name),
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+				{
+					Create: &types.CreateGlobalSecondaryIndexAction{
+						IndexName:  spec.IndexName,
+						KeySchema:  spec.KeySchema,
+						Projection: spec.Projection,
+					},
+				},
+			},
+		})
+		var inUse *types.ResourceInUseException
+		if errors.As(err, &inUse) {
+			// the table is still being created or another index is building
+			time.Sleep(messageIndexPoll)
+			continue
+		}
+		if err != nil {
+			return built, fmt.Errorf("failed to add %s to %s: %w", name, tableName, err)
+		}
+		built = true
+		log.Printf("Building %s on %s table\n", name, tableName)
+	}
+}
+
+// markTombstones adds the messages deleted before messagesTombstoneIndex
+// existed to it.
+func markTombstones(client *dynamodb.Client, tableName string) error {
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
+			TableName:            aws.String(tableName),
+			FilterExpression:     aws.String("attribute_exists(deletedAt) AND attribute_not_exists(tombstone)"),
+			ProjectionExpression: aws.String("chatId, id"),
+			ExclusiveStartKey:    lastEvaluatedKey,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan deleted messages: %w", err)
+		}
+
+		for _, key := range out.Items {
+			_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+				TableName:        aws.String(tableName),
+				Key:              key,
+				UpdateExpression: aws.String("SET tombstone = :d"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":d": &types.AttributeValueMemberS{Value: tombstoneMark},
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to mark deleted message: %w", err)
+			}
+		}
+
+		if out.LastEvaluatedKey == nil {
+			return nil
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+}
+
 func CreateMessage(client *dynamodb.Client, tableName string, msg Message) error {
 	item, err := attributevalue.MarshalMap(msg)
 	if err != nil {
@@ -78,67 +280,417 @@ func GetChatMessage(client *dynamodb.Client, tableName, chatID, msgID string) (*
 	return &msg, nil
 }
 
-func GetAllChatMessages(client *dynamodb.Client, tableName, chatID string) ([]Message, error) {
-	out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+// MessageKey is the time index key of msg, used as a pagination cursor.
+func MessageKey(msg Message) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"chatId":    &types.AttributeValueMemberS{Value: msg.ChatID},
+		"id":        &types.AttributeValueMemberS{Value: msg.ID},
+		"timestamp": &types.AttributeValueMemberN{Value: fmt.Sprint(msg.Timestamp)},
+	}
+}
+
+// ReplyKey is the thread index key of a reply, used as a pagination cursor.
+func ReplyKey(msg Message) map[string]types.AttributeValue {
+	key := MessageKey(msg)
+	key["parentId"] = &types.AttributeValueMemberS{Value: msg.ParentID}
+	return key
+}
+
+// GetChatMessagePage reads up to limit of a chat's top-level messages in
+// time order, then ID order, starting after the message startKey names,
+// oldest first when forward and newest first otherwise. Replies are left to
+// their threads. more reports whether anything further lies in that
+// direction.
+func GetChatMessagePage(client *dynamodb.Client, tableName, chatID string, startKey map[string]types.AttributeValue, limit int32, forward bool) (messages []Message, more bool, err error) {
+	return queryMessagesAfter(client, &dynamodb.QueryInput{
 		TableName:              aws.String(tableName),
+		IndexName:              aws.String(messagesTimeIndex),
 		KeyConditionExpression: aws.String("chatId = :c"),
+		FilterExpression:       aws.String("attribute_not_exists(parentId)"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":c": &types.AttributeValueMemberS{Value: chatID},
 		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}, startKey, limit, forward)
+}
+
+// GetThreadPage reads up to limit replies to a parent message, paging the
+// same way as GetChatMessagePage.
+func GetThreadPage(client *dynamodb.Client, tableName, parentID string, startKey map[string]types.AttributeValue, limit int32, forward bool) (messages []Message, more bool, err error) {
+	return queryMessagesAfter(client, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(messagesThreadIndex),
+		KeyConditionExpression: aws.String("parentId = :p"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":p": &types.AttributeValueMemberS{Value: parentID},
+		},
+	}, startKey, limit, forward)
+}
+
+// queryMessagesAfter reads a page of input's messages ordered by timestamp
+// and then ID, starting past the message startKey names. The index only
+// orders by timestamp, so instead of resuming from startKey it reads from
+// startKey's second onwards, drops what the cursor already covered, and
+// keeps reading until it holds the whole second its page ends in.
+func queryMessagesAfter(client *dynamodb.Client, input *dynamodb.QueryInput, startKey map[string]types.AttributeValue, limit int32, forward bool) ([]Message, bool, error) {
+	input.ScanIndexForward = aws.Bool(forward)
+
+	var from Message
+	if startKey != nil {
+		if err := attributevalue.UnmarshalMap(startKey, &from); err != nil {
+			return nil, false, fmt.Errorf("failed to read cursor: %w", err)
+		}
+		op := "<="
+		if forward {
+			op = ">="
+		}
+		*input.KeyConditionExpression += " AND #ts " + op + " :t"
+		if input.ExpressionAttributeNames == nil {
+			input.ExpressionAttributeNames = map[string]string{}
+		}
+		input.ExpressionAttributeNames["#ts"] = "timestamp"
+		input.ExpressionAttributeValues[":t"] = &types.AttributeValueMemberN{Value: fmt.Sprint(from.Timestamp)}
+	}
+
+	// before reports whether a comes ahead of b in the page's direction
+	before := func(a, b Message) bool {
+		if a.Timestamp != b.Timestamp {
+			return (a.Timestamp < b.Timestamp) == forward
+		}
+		return (a.ID < b.ID) == forward
 	}
 
 	var messages []Message
-	err = attributevalue.UnmarshalListOfMaps(out.Items, &messages)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal messages: %w", err)
+	for {
+		input.Limit = aws.Int32(limit + 1)
+		out, err := client.Query(context.TODO(), input)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to query messages: %w", err)
+		}
+
+		var page []Message
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, false, fmt.Errorf("failed to unmarshal messages: %w", err)
+		}
+		for _, msg := range page {
+			if startKey == nil || before(from, msg) {
+				messages = append(messages, msg)
+			}
+		}
+		slices.SortFunc(messages, func(a, b Message) int {
+			switch {
+			case before(a, b):
+				return -1
+			case before(b, a):
+				return 1
+			}
+			return 0
+		})
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		if int32(len(messages)) > limit {
+			// done once the read has moved past the page's last second
+			var last Message
+			if err := attributevalue.UnmarshalMap(out.LastEvaluatedKey, &last); err != nil {
+				return nil, false, fmt.Errorf("failed to unmarshal messages: %w", err)
+			}
+			if last.Timestamp != messages[limit-1].Timestamp {
+				break
+			}
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
 	}
-	return messages, nil
+
+	if int32(len(messages)) > limit {
+		return messages[:limit], true, nil
+	}
+	return messages, false, nil
 }
 
-func UpdateMessage(client *dynamodb.Client, tableName, chatID, msgID string, updates map[string]types.AttributeValue) error {
-	updateExpr := "SET"
-	exprAttrValues := map[string]types.AttributeValue{}
-	i := 0
+// queryMessagePage runs input until it has collected limit messages or
+// run out. A filter can leave a page short, so it keeps reading, asking
+// only for what's still missing so it never reads past the last item kept.
+func queryMessagePage(client *dynamodb.Client, input *dynamodb.QueryInput, limit int32) ([]Message, bool, error) {
+	var messages []Message
+	for {
+		input.Limit = aws.Int32(limit - int32(len(messages)))
+		out, err := client.Query(context.TODO(), input)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to query messages: %w", err)
+		}
+
+		var page []Message
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, false, fmt.Errorf("failed to unmarshal messages: %w", err)
+		}
+		messages = append(messages, page...)
 
-	for k, v := range updates {
-		if i > 0 {
-			updateExpr += ","
+		if out.LastEvaluatedKey == nil {
+			return messages, false, nil
 		}
-		placeholder := fmt.Sprintf(":%s", k)
-		updateExpr += fmt.Sprintf(" %s = %s", k, placeholder)
-		exprAttrValues[placeholder] = v
-		i++
+		if int32(len(messages)) >= limit {
+			return messages, true, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
 	}
+}
 
-	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+// AddReply bumps a parent message's reply count and records reply as its
+// latest reply.
+func AddReply(client *dynamodb.Client, tableName string, reply Message) (*Message, error) {
+	out, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"chatId": &types.AttributeValueMemberS{Value: reply.ChatID},
+			"id":     &types.AttributeValueMemberS{Value: reply.ParentID},
+		},
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		UpdateExpression:    aws.String("ADD replyCount :one SET lastReplyId = :id, lastReplyBy = :by, lastReplyAt = :at"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+			":id":  &types.AttributeValueMemberS{Value: reply.ID},
+			":by":  &types.AttributeValueMemberS{Value: reply.SenderID},
+			":at":  &types.AttributeValueMemberN{Value: fmt.Sprint(reply.Timestamp)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update thread: %w", err)
+	}
+
+	var parent Message
+	if err := attributevalue.UnmarshalMap(out.Attributes, &parent); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return &parent, nil
+}
+
+// RemoveReply lowers a parent message's reply count after one of its
+// replies is deleted.
+func RemoveReply(client *dynamodb.Client, tableName string, reply Message) (*Message, error) {
+	out, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"chatId": &types.AttributeValueMemberS{Value: reply.ChatID},
+			"id":     &types.AttributeValueMemberS{Value: reply.ParentID},
+		},
+		ConditionExpression: aws.String("replyCount > :zero"),
+		UpdateExpression:    aws.String("ADD replyCount :minus"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero":  &types.AttributeValueMemberN{Value: "0"},
+			":minus": &types.AttributeValueMemberN{Value: "-1"},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update thread: %w", err)
+	}
+
+	var parent Message
+	if err := attributevalue.UnmarshalMap(out.Attributes, &parent); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return &parent, nil
+}
+
+// GetChatMessagesSince returns up to limit top-level messages in a chat
+// stamped at or after since, oldest first, reading only those through the
+// chat's timestamp index. more reports whether there were others left.
+func GetChatMessagesSince(client *dynamodb.Client, tableName, chatID string, since int64, limit int32) (messages []Message, more bool, err error) {
+	return queryMessagePage(client, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(messagesTimeIndex),
+		KeyConditionExpression: aws.String("chatId = :c AND #ts >= :t"),
+		FilterExpression:       aws.String("attribute_not_exists(parentId)"),
+		ExpressionAttributeNames: map[string]string{
+			"#ts": "timestamp",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":c": &types.AttributeValueMemberS{Value: chatID},
+			":t": &types.AttributeValueMemberN{Value: fmt.Sprint(since)},
+		},
+		ScanIndexForward: aws.Bool(true),
+	}, limit)
+}
+
+// SoftDeleteMessage tombstones a message for everyone. The item is kept,
+// content included, until PurgeDeletedMessages removes it.
+func SoftDeleteMessage(client *dynamodb.Client, tableName, chatID, msgID, deletedBy string, deletedAt int64) (*Message, error) {
+	out, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"chatId": &types.AttributeValueMemberS{Value: chatID},
 			"id":     &types.AttributeValueMemberS{Value: msgID},
 		},
-		UpdateExpression:          aws.String(updateExpr),
-		ExpressionAttributeValues: exprAttrValues,
-		ReturnValues:              types.ReturnValueUpdatedNew,
+		ConditionExpression: aws.String("attribute_exists(id) AND attribute_not_exists(deletedAt)"),
+		UpdateExpression:    aws.String("SET deletedAt = :t, deletedBy = :u, tombstone = :d"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":t": &types.AttributeValueMemberN{Value: fmt.Sprint(deletedAt)},
+			":u": &types.AttributeValueMemberS{Value: deletedBy},
+			":d": &types.AttributeValueMemberS{Value: tombstoneMark},
+		},
+		ReturnValues: types.ReturnValueAllNew,
 	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return nil, ErrMessageDeleted
+	}
 	if err != nil {
-		return fmt.Errorf("failed to update message: %w", err)
+		return nil, fmt.Errorf("failed to delete message: %w", err)
 	}
-	return nil
+
+	var msg Message
+	if err := attributevalue.UnmarshalMap(out.Attributes, &msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return &msg, nil
 }
 
-func DeleteMessage(client *dynamodb.Client, tableName, chatID, msgID string) error {
-	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+// HideMessage deletes a message for one user only.
+func HideMessage(client *dynamodb.Client, tableName, chatID, msgID, userID string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"chatId": &types.AttributeValueMemberS{Value: chatID},
 			"id":     &types.AttributeValueMemberS{Value: msgID},
 		},
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		UpdateExpression:    aws.String("ADD hiddenFor :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberSS{Value: []string{userID}},
+		},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to delete message: %w", err)
+		return fmt.Errorf("failed to hide message: %w", err)
 	}
 	return nil
 }
+
+// PurgeDeletedMessages hard-deletes messages tombstoned before cutoff,
+// along with their edit history, their mentions and the file shares they
+// alone held, and returns what it removed. Expired tombstones are read
+// through messagesTombstoneIndex. Replies go when their own tombstones
+// expire; a parent whose thread still has live replies keeps its
+// tombstone so they stay reachable.
+func PurgeDeletedMessages(client *dynamodb.Client, tableName, revisionsTable, sharesTable, mentionsTable string, cutoff int64) ([]Message, error) {
+	var purged []Message
+	var lastEvaluatedKey map[string]types.AttributeValue
+	tables := purgeTables{messages: tableName, revisions: revisionsTable, shares: sharesTable, mentions: mentionsTable}
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			IndexName:              aws.String(messagesTombstoneIndex),
+			KeyConditionExpression: aws.String("tombstone = :d AND deletedAt < :t"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":d": &types.AttributeValueMemberS{Value: tombstoneMark},
+				":t": &types.AttributeValueMemberN{Value: fmt.Sprint(cutoff)},
+			},
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return purged, fmt.Errorf("failed to query deleted messages: %w", err)
+		}
+
+		var page []Message
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return purged, fmt.Errorf("failed to unmarshal messages: %w", err)
+		}
+		for _, msg := range page {
+			if msg.ReplyCount > 0 {
+				continue
+			}
+			if err := purgeMessage(client, tables, msg); err != nil {
+				return purged, err
+			}
+			purged = append(purged, msg)
+		}
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return purged, nil
+}
+
+type purgeTables struct {
+	messages, revisions, shares, mentions string
+}
+
+// purgeMessage removes the tombstoned msg and everything kept for it.
+// Deleted parents can't take new replies, so a thread found empty stays so.
+func purgeMessage(client *dynamodb.Client, tables purgeTables, msg Message) error {
+	revisions, err := GetMessageRevisions(client, tables.revisions, msg.ID)
+	if err != nil {
+		return err
+	}
+	media := slices.Clone(msg.Media)
+	requests := make([]types.WriteRequest, 0, len(revisions))
+	for _, rev := range revisions {
+		media = append(media, rev.Media...)
+		requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{
+			Key: map[string]types.AttributeValue{
+				"messageId": &types.AttributeValueMemberS{Value: rev.MessageID},
+				"revision":  &types.AttributeValueMemberN{Value: fmt.Sprint(rev.Revision)},
+			},
+		}})
+	}
+	slices.Sort(media)
+	if err := UnshareFiles(client, tables.shares, msg.ChatID, slices.Compact(media), msg.ID); err != nil {
+		return err
+	}
+	if err := RemoveMentions(client, tables.mentions, msg.ID, msg.Mentions); err != nil {
+		return err
+	}
+	if err := batchWrite(client, tables.revisions, requests); err != nil {
+		return err
+	}
+
+	// only purge if it's still a tombstone
+	_, err = client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tables.messages),
+		Key: map[string]types.AttributeValue{
+			"chatId": &types.AttributeValueMemberS{Value: msg.ChatID},
+			"id":     &types.AttributeValueMemberS{Value: msg.ID},
+		},
+		ConditionExpression: aws.String("attribute_exists(deletedAt)"),
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &condErr) {
+		return fmt.Errorf("failed to purge message: %w", err)
+	}
+	return nil
+}
+
+// ForEachMessage scans every message that hasn't been deleted, handing
+// each page of them to fn.
+func ForEachMessage(client *dynamodb.Client, tableName string, fn func([]Message) error) error {
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
+			TableName:         aws.String(tableName),
+			FilterExpression:  aws.String("attribute_not_exists(deletedAt)"),
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan messages: %w", err)
+		}
+
+		var page []Message
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return fmt.Errorf("failed to unmarshal messages: %w", err)
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+
+		if out.LastEvaluatedKey == nil {
+			return nil
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrTooManyReactions is returned when reacting with a new emoji to a
+// message that already has as many different ones as it may.
+var ErrTooManyReactions = errors.New("message has too many different reactions")
+
+// AddReaction records userID reacting to a message with emoji and returns
+// how many users now have, and whether that changed anything: reacting
+// twice with the same emoji is a no-op. A message takes at most maxKinds
+// different emojis.
+func AddReaction(client *dynamodb.Client, tableName, chatID, msgID, emoji, userID string, maxKinds int) (count int, changed bool, err error) {
+	key := map[string]types.AttributeValue{
+		"chatId": &types.AttributeValueMemberS{Value: chatID},
+		"id":     &types.AttributeValueMemberS{Value: msgID},
+	}
+
+	// ADD can't create the enclosing map, so make sure it's there first
+	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 key,
+		ConditionExpression: aws.String("attribute_exists(id) AND attribute_not_exists(reactions)"),
+		UpdateExpression:    aws.String("SET reactions = :empty"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+		},
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &condErr) {
+		return 0, false, fmt.Errorf("failed to add reaction: %w", err)
+	}
+
+	out, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key:       key,
+		ConditionExpression: aws.String("attribute_exists(id) AND attribute_not_exists(deletedAt) AND " +
+			"NOT contains(reactions.#e, :id) AND (attribute_exists(reactions.#e) OR size(reactions) < :max)"),
+		UpdateExpression: aws.String("ADD reactions.#e :u"),
+		ExpressionAttributeNames: map[string]string{
+			"#e": emoji,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id":  &types.AttributeValueMemberS{Value: userID},
+			":u":   &types.AttributeValueMemberSS{Value: []string{userID}},
+			":max": &types.AttributeValueMemberN{Value: fmt.Sprint(maxKinds)},
+		},
+		ReturnValues:                        types.ReturnValueUpdatedNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	if errors.As(err, &condErr) {
+		// work out which part of the condition failed from the item as it was
+		old := condErr.Item
+		_, deleted := old["deletedAt"]
+		switch {
+		case old == nil || deleted:
+			return 0, false, ErrMessageDeleted
+		case hasReacted(old, emoji, userID):
+			return reactionCount(old, emoji), false, nil
+		}
+		return 0, false, ErrTooManyReactions
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to add reaction: %w", err)
+	}
+	return reactionCount(out.Attributes, emoji), true, nil
+}
+
+// RemoveReaction takes back userID's emoji reaction and returns how many
+// users still have it, and whether they had it to begin with. DynamoDB
+// drops the set once it's empty.
+func RemoveReaction(client *dynamodb.Client, tableName, chatID, msgID, emoji, userID string) (count int, changed bool, err error) {
+	out, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"chatId": &types.AttributeValueMemberS{Value: chatID},
+			"id":     &types.AttributeValueMemberS{Value: msgID},
+		},
+		ConditionExpression: aws.String("contains(reactions.#e, :id)"),
+		UpdateExpression:    aws.String("DELETE reactions.#e :u"),
+		ExpressionAttributeNames: map[string]string{
+			"#e": emoji,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: userID},
+			":u":  &types.AttributeValueMemberSS{Value: []string{userID}},
+		},
+		ReturnValues:                        types.ReturnValueUpdatedNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return reactionCount(condErr.Item, emoji), false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to remove reaction: %w", err)
+	}
+	return reactionCount(out.Attributes, emoji), true, nil
+}
+
+func reactionUsers(attrs map[string]types.AttributeValue, emoji string) []string {
+	reactions, ok := attrs["reactions"].(*types.AttributeValueMemberM)
+	if !ok {
+		return nil
+	}
+	users, ok := reactions.Value[emoji].(*types.AttributeValueMemberSS)
+	if !ok {
+		return nil
+	}
+	return users.Value
+}
+
+func reactionCount(attrs map[string]types.AttributeValue, emoji string) int {
+	return len(reactionUsers(attrs, emoji))
+}
+
+func hasReacted(attrs map[string]types.AttributeValue, emoji, userID string) bool {
+	return slices.Contains(reactionUsers(attrs, emoji), userID)
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func CreateChatReadsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("chatId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("userId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("chatId"),
+				KeyType:       types.KeyTypeHash, // Partition Key
+			},
+			{
+				AttributeName: aws.String("userId"),
+				KeyType:       types.KeyTypeRange, // Sort Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	return err
+}
+
+// SetReadCursor stores the user's read position in a chat. Cursors only move
+// forward; an older position leaves the stored cursor untouched and reports
+// false.
+func SetReadCursor(client *dynamodb.Client, tableName string, cursor ReadCursor) (bool, error) {
+	item, err := attributevalue.MarshalMap(cursor)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal read cursor: %w", err)
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(lastReadAt) OR lastReadAt <= :t"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":t": &types.AttributeValueMemberN{Value: fmt.Sprint(cursor.LastReadAt)},
+		},
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to save read cursor: %w", err)
+	}
+	return true, nil
+}
+
+// GetReadCursor returns the user's read position in a chat, or a zero
+// cursor if they've never read it.
+func GetReadCursor(client *dynamodb.Client, tableName, chatID, userID string) (*ReadCursor, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"chatId": &types.AttributeValueMemberS{Value: chatID},
+			"userId": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get read cursor: %w", err)
+	}
+
+	cursor := ReadCursor{ChatID: chatID, UserID: userID}
+	if out.Item == nil {
+		return &cursor, nil
+	}
+	if err := attributevalue.UnmarshalMap(out.Item, &cursor); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal read cursor: %w", err)
+	}
+	return &cursor, nil
+}
+
+// GetReadCursors returns the user's read position in each chat, keyed by
+// chat ID, leaving out chats they've never read.
+func GetReadCursors(client *dynamodb.Client, tableName, userID string, chatIDs []string) (map[string]ReadCursor, error) {
+	cursors := make(map[string]ReadCursor, len(chatIDs))
+
+	for start := 0; start < len(chatIDs); start += 100 {
+		end := min(start+100, len(chatIDs))
+
+		keys := make([]map[string]types.AttributeValue, 0, end-start)
+		for _, chatID := range chatIDs[start:end] {
+			keys = append(keys, map[string]types.AttributeValue{
+				"chatId": &types.AttributeValueMemberS{Value: chatID},
+				"userId": &types.AttributeValueMemberS{Value: userID},
+			})
+		}
+
+		request := map[string]types.KeysAndAttributes{tableName: {Keys: keys}}
+		for len(request) > 0 {
+			out, err := client.BatchGetItem(context.TODO(), &dynamodb.BatchGetItemInput{
+				RequestItems: request,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get read cursors: %w", err)
+			}
+
+			var page []ReadCursor
+			if err := attributevalue.UnmarshalListOfMaps(out.Responses[tableName], &page); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal read cursors: %w", err)
+			}
+			for _, cursor := range page {
+				cursors[cursor.ChatID] = cursor
+			}
+			request = out.UnprocessedKeys
+		}
+	}
+
+	return cursors, nil
+}
+
+// CountUnread counts top-level messages in a chat newer than since that
+// weren't sent by the user. Only the messages after since are read, through
+// the chat's timestamp index.
+func CountUnread(client *dynamodb.Client, tableName, chatID, userID string, since int64) (int, error) {
+	count := 0
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			IndexName:              aws.String(messagesTimeIndex),
+			KeyConditionExpression: aws.String("chatId = :c AND #ts > :t"),
+			FilterExpression:       aws.String("senderId <> :u AND attribute_not_exists(parentId) AND attribute_not_exists(deletedAt) AND NOT contains(hiddenFor, :u)"),
+			ExpressionAttributeNames: map[string]string{
+				"#ts": "timestamp",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":c": &types.AttributeValueMemberS{Value: chatID},
+				":t": &types.AttributeValueMemberN{Value: fmt.Sprint(since)},
+				":u": &types.AttributeValueMemberS{Value: userID},
+			},
+			Select:            types.SelectCount,
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to count unread messages: %w", err)
+		}
+
+		count += int(out.Count)
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return count, nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrMessageChanged is returned by EditMessage when the message was edited
+// or deleted since the caller read it.
+var ErrMessageChanged = errors.New("message changed since it was read")
+
+func CreateMessageRevisionsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("messageId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("revision"),
+				AttributeType: types.ScalarAttributeTypeN,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("messageId"),
+				KeyType:       types.KeyTypeHash, // Partition Key
+			},
+			{
+				AttributeName: aws.String("revision"),
+				KeyType:       types.KeyTypeRange, // Sort Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	return err
+}
+
+// EditMessage replaces the content and media of msg, archiving what they
+// were as a revision in revisionsTable. Both writes happen in one
+// transaction, guarded on msg still being at the revision the caller read.
+func EditMessage(client *dynamodb.Client, tableName, revisionsTable string, msg Message, content string, media []string, editedBy string, editedAt int64) (*Message, error) {
+	prev, err := attributevalue.MarshalMap(MessageRevision{
+		MessageID: msg.ID,
+		Revision:  msg.Revision,
+		ChatID:    msg.ChatID,
+		Content:   msg.Content,
+		Media:     msg.Media,
+		EditedBy:  editedBy,
+		EditedAt:  editedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal revision: %w", err)
+	}
+
+	cond := expression.AttributeExists(expression.Name("id")).
+		And(expression.AttributeNotExists(expression.Name("deletedAt")))
+	if msg.Revision == 0 {
+		cond = cond.And(expression.AttributeNotExists(expression.Name("revision")))
+	} else {
+		cond = cond.And(expression.Name("revision").Equal(expression.Value(msg.Revision)))
+	}
+	expr, err := expression.NewBuilder().
+		WithCondition(cond).
+		WithUpdate(expression.Set(expression.Name("content"), expression.Value(content)).
+			Set(expression.Name("media"), expression.Value(media)).
+			Set(expression.Name("edited"), expression.Value(true)).
+			Set(expression.Name("editedAt"), expression.Value(editedAt)).
+			Set(expression.Name("revision"), expression.Value(msg.Revision+1))).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("error in expression builder: %w", err)
+	}
+
+	_, err = client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Update: &types.Update{
+					TableName: aws.String(tableName),
+					Key: map[string]types.AttributeValue{
+						"chatId": &types.AttributeValueMemberS{Value: msg.ChatID},
+						"id":     &types.AttributeValueMemberS{Value: msg.ID},
+					},
+					ConditionExpression:       expr.Condition(),
+					ExpressionAttributeNames:  expr.Names(),
+					ExpressionAttributeValues: expr.Values(),
+					UpdateExpression:          expr.Update(),
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName:           aws.String(revisionsTable),
+					Item:                prev,
+					ConditionExpression: aws.String("attribute_not_exists(messageId)"),
+				},
+			},
+		},
+	})
+	var cancelled *types.TransactionCanceledException
+	if errors.As(err, &cancelled) {
+		return nil, ErrMessageChanged
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	edited := msg
+	edited.Content = content
+	edited.Media = media
+	edited.Edited = true
+	edited.EditedAt = editedAt
+	edited.Revision = msg.Revision + 1
+	return &edited, nil
+}
+
+// GetMessageRevisions returns the earlier versions of a message, oldest first.
+func GetMessageRevisions(client *dynamodb.Client, tableName, messageID string) ([]MessageRevision, error) {
+	var revisions []MessageRevision
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			KeyConditionExpression: aws.String("messageId = :m"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":m": &types.AttributeValueMemberS{Value: messageID},
+			},
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query revisions: %w", err)
+		}
+
+		var page []MessageRevision
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal revisions: %w", err)
+		}
+		revisions = append(revisions, page...)
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return revisions, nil
+}
@@ -0,0 +1,417 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// scheduledDueIndex orders scheduled messages of each status by when
+// they're due, so the scheduler reads pending ones that have come up.
+const scheduledDueIndex = "status-sendAt-index"
+
+// ErrScheduledNotPending is returned when changing a scheduled message
+// that has already been sent, is being sent, or was cancelled.
+var ErrScheduledNotPending = errors.New("scheduled message is no longer pending")
+
+func CreateScheduledMessagesTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("chatId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("status"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("sendAt"),
+				AttributeType: types.ScalarAttributeTypeN,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("chatId"),
+				KeyType:       types.KeyTypeHash, // Partition Key
+			},
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeRange, // Sort Key
+			},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String(scheduledDueIndex),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("status"),
+						KeyType:       types.KeyTypeHash,
+					},
+					{
+						AttributeName: aws.String("sendAt"),
+						KeyType:       types.KeyTypeRange,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	return err
+}
+
+func CreateScheduledMessage(client *dynamodb.Client, tableName string, msg ScheduledMessage) error {
+	item, err := attributevalue.MarshalMap(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scheduled message: %w", err)
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create scheduled message: %w", err)
+	}
+	return nil
+}
+
+func GetScheduledMessage(client *dynamodb.Client, tableName, chatID, id string) (*ScheduledMessage, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key:       scheduledKey(chatID, id),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled message: %w", err)
+	}
+	if out.Item == nil {
+		return nil, fmt.Errorf("scheduled message not found")
+	}
+
+	var msg ScheduledMessage
+	if err := attributevalue.UnmarshalMap(out.Item, &msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal scheduled message: %w", err)
+	}
+	return &msg, nil
+}
+
+// GetUserScheduledMessages returns what a user has scheduled in a chat,
+// oldest first, leaving out messages that are being sent right now.
+func GetUserScheduledMessages(client *dynamodb.Client, tableName, chatID, userID string) ([]ScheduledMessage, error) {
+	messages := []ScheduledMessage{}
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			KeyConditionExpression: aws.String("chatId = :c"),
+			FilterExpression:       aws.String("senderId = :u AND #s <> :sending"),
+			ExpressionAttributeNames: map[string]string{
+				"#s": "status",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":c":       &types.AttributeValueMemberS{Value: chatID},
+				":u":       &types.AttributeValueMemberS{Value: userID},
+				":sending": &types.AttributeValueMemberS{Value: ScheduledSending},
+			},
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query scheduled messages: %w", err)
+		}
+
+		var page []ScheduledMessage
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal scheduled messages: %w", err)
+		}
+		messages = append(messages, page...)
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return messages, nil
+}
+
+// UpdateScheduledMessage saves msg over a scheduled message that is still
+// pending, or one that failed, which puts it back to pending.
+func UpdateScheduledMessage(client *dynamodb.Client, tableName string, msg ScheduledMessage) error {
+	msg.Status = ScheduledPending
+	msg.Error = ""
+	msg.ClaimedAt = 0
+	item, err := attributevalue.MarshalMap(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scheduled message: %w", err)
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("#s IN (:pending, :failed)"),
+		ExpressionAttributeNames: map[string]string{
+			"#s": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: ScheduledPending},
+			":failed":  &types.AttributeValueMemberS{Value: ScheduledFailed},
+		},
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return ErrScheduledNotPending
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update scheduled message: %w", err)
+	}
+	return nil
+}
+
+// CancelScheduledMessage deletes a scheduled message before it's sent.
+func CancelScheduledMessage(client *dynamodb.Client, tableName, chatID, id string) error {
+	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 scheduledKey(chatID, id),
+		ConditionExpression: aws.String("#s IN (:pending, :failed)"),
+		ExpressionAttributeNames: map[string]string{
+			"#s": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: ScheduledPending},
+			":failed":  &types.AttributeValueMemberS{Value: ScheduledFailed},
+		},
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return ErrScheduledNotPending
+	}
+	if err != nil {
+		return fmt.Errorf("failed to cancel scheduled message: %w", err)
+	}
+	return nil
+}
+
+// GetDueScheduledMessages returns the pending messages due at or before now.
+func GetDueScheduledMessages(client *dynamodb.Client, tableName string, now int64) ([]ScheduledMessage, error) {
+	var messages []ScheduledMessage
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			IndexName:              aws.String(scheduledDueIndex),
+			KeyConditionExpression: aws.String("#s = :pending AND sendAt <= :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#s": "status",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pending": &types.AttributeValueMemberS{Value: ScheduledPending},
+				":now":     &types.AttributeValueMemberN{Value: fmt.Sprint(now)},
+			},
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query due scheduled messages: %w", err)
+		}
+
+		var page []ScheduledMessage
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal scheduled messages: %w", err)
+		}
+		messages = append(messages, page...)
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return messages, nil
+}
+
+// ClaimScheduledMessage marks a pending message that's due by now as being
+// sent since now and returns it as stored, with any edits made since it
+// was read. It
+// returns ErrScheduledNotPending if another instance got there first or
+// the sender cancelled or postponed it.
+func ClaimScheduledMessage(client *dynamodb.Client, tableName, chatID, id string, now int64) (*ScheduledMessage, error) {
+	out, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 scheduledKey(chatID, id),
+		ConditionExpression: aws.String("#s = :pending AND sendAt <= :now"),
+		UpdateExpression:    aws.String("SET #s = :sending, claimedAt = :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#s": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: ScheduledPending},
+			":sending": &types.AttributeValueMemberS{Value: ScheduledSending},
+			":now":     &types.AttributeValueMemberN{Value: fmt.Sprint(now)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return nil, ErrScheduledNotPending
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim scheduled message: %w", err)
+	}
+
+	var claimed ScheduledMessage
+	if err := attributevalue.UnmarshalMap(out.Attributes, &claimed); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal scheduled message: %w", err)
+	}
+	return &claimed, nil
+}
+
+// ReleaseScheduledMessage puts a claimed message back to pending so the
+// next run of the scheduler tries it again.
+func ReleaseScheduledMessage(client *dynamodb.Client, tableName, chatID, id string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 scheduledKey(chatID, id),
+		ConditionExpression: aws.String("#s = :sending"),
+		UpdateExpression:    aws.String("SET #s = :pending REMOVE claimedAt"),
+		ExpressionAttributeNames: map[string]string{
+			"#s": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sending": &types.AttributeValueMemberS{Value: ScheduledSending},
+			":pending": &types.AttributeValueMemberS{Value: ScheduledPending},
+		},
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return ErrScheduledNotPending
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release scheduled message: %w", err)
+	}
+	return nil
+}
+
+// FailScheduledMessage records why a claimed message couldn't be sent.
+func FailScheduledMessage(client *dynamodb.Client, tableName, chatID, id, reason string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tableName),
+		Key:              scheduledKey(chatID, id),
+		UpdateExpression: aws.String("SET #s = :failed, #e = :e REMOVE claimedAt"),
+		ExpressionAttributeNames: map[string]string{
+			"#s": "status",
+			"#e": "error",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":failed": &types.AttributeValueMemberS{Value: ScheduledFailed},
+			":e":      &types.AttributeValueMemberS{Value: reason},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark scheduled message failed: %w", err)
+	}
+	return nil
+}
+
+// ExpireScheduledClaims marks messages claimed before the given time and
+// still not sent as failed, with reason, and returns them. Their instance
+// most likely died mid-send, so they may or may not have gone out.
+func ExpireScheduledClaims(client *dynamodb.Client, tableName string, before int64, reason string) ([]ScheduledMessage, error) {
+	var stale []ScheduledMessage
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			IndexName:              aws.String(scheduledDueIndex),
+			KeyConditionExpression: aws.String("#s = :sending"),
+			FilterExpression:       aws.String("claimedAt < :before"),
+			ExpressionAttributeNames: map[string]string{
+				"#s": "status",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":sending": &types.AttributeValueMemberS{Value: ScheduledSending},
+				":before":  &types.AttributeValueMemberN{Value: fmt.Sprint(before)},
+			},
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query claimed scheduled messages: %w", err)
+		}
+
+		var page []ScheduledMessage
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal scheduled messages: %w", err)
+		}
+		stale = append(stale, page...)
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	expired := make([]ScheduledMessage, 0, len(stale))
+	for _, msg := range stale {
+		// only if no one sent, failed or reclaimed it since the query
+		_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName:           aws.String(tableName),
+			Key:                 scheduledKey(msg.ChatID, msg.ID),
+			ConditionExpression: aws.String("#s = :sending AND claimedAt = :claimedAt"),
+			UpdateExpression:    aws.String("SET #s = :failed, #e = :e REMOVE claimedAt"),
+			ExpressionAttributeNames: map[string]string{
+				"#s": "status",
+				"#e": "error",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":sending":   &types.AttributeValueMemberS{Value: ScheduledSending},
+				":claimedAt": &types.AttributeValueMemberN{Value: fmt.Sprint(msg.ClaimedAt)},
+				":failed":    &types.AttributeValueMemberS{Value: ScheduledFailed},
+				":e":         &types.AttributeValueMemberS{Value: reason},
+			},
+		})
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			continue
+		}
+		if err != nil {
+			return expired, fmt.Errorf("failed to expire scheduled message claim: %w", err)
+		}
+		msg.Status, msg.Error, msg.ClaimedAt = ScheduledFailed, reason, 0
+		expired = append(expired, msg)
+	}
+	return expired, nil
+}
+
+// DeleteScheduledMessage removes a scheduled message once it's been sent.
+func DeleteScheduledMessage(client *dynamodb.Client, tableName, chatID, id string) error {
+	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key:       scheduledKey(chatID, id),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete scheduled message: %w", err)
+	}
+	return nil
+}
+
+func scheduledKey(chatID, id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"chatId": &types.AttributeValueMemberS{Value: chatID},
+		"id":     &types.AttributeValueMemberS{Value: id},
+	}
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrSessionStale is returned by RotateSession when the session was revoked
+// or its refresh token was already rotated by someone else.
+var ErrSessionStale = errors.New("session revoked or token already used")
+
+func CreateSessionsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("userId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash, // Partition Key
+			},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("userId-index"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("userId"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	return err
+}
+
+func CreateSession(client *dynamodb.Client, tableName string, session Session) error {
+	item, err := attributevalue.MarshalMap(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"), // prevent overwrite
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+func GetSession(client *dynamodb.Client, tableName, id string) (*Session, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if out.Item == nil {
+		return nil, fmt.Errorf("session not found")
+	}
+
+	var session Session
+	if err := attributevalue.UnmarshalMap(out.Item, &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+	}
+	return &session, nil
+}
+
+// RotateSession swaps the session's current refresh token ID from oldTokenID
+// to next.TokenID, recording next's expiry and last use. It fails with
+// ErrSessionStale if the session is revoked or oldTokenID is no longer current.
+func RotateSession(client *dynamodb.Client, tableName, oldTokenID string, next Session) error {
+	expr, err := expression.NewBuilder().
+		WithCondition(expression.Name("tokenId").Equal(expression.Value(oldTokenID)).
+			And(expression.Name("revoked").Equal(expression.Value(false)))).
+		WithUpdate(expression.Set(expression.Name("tokenId"), expression.Value(next.TokenID)).
+			Set(expression.Name("expiresAt"), expression.Value(next.ExpiresAt)).
+			Set(expression.Name("lastUsed"), expression.Value(next.LastUsed)).
+			Set(expression.Name("ip"), expression.Value(next.IP)).
+			Set(expression.Name("userAgent"), expression.Value(next.UserAgent))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("error in expression builder: %w", err)
+	}
+
+	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: next.ID},
+		},
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return ErrSessionStale
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate session: %w", err)
+	}
+	return nil
+}
+
+func RevokeSession(client *dynamodb.Client, tableName, id string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		UpdateExpression:    aws.String("SET revoked = :r"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":r": &types.AttributeValueMemberBOOL{Value: true},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// GetUserSessions returns every session, revoked or not, the user has started.
+func GetUserSessions(client *dynamodb.Client, tableName, userID string) ([]Session, error) {
+	var sessions []Session
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			IndexName:              aws.String("userId-index"),
+			KeyConditionExpression: aws.String("userId = :u"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":u": &types.AttributeValueMemberS{Value: userID},
+			},
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query sessions: %w", err)
+		}
+
+		var page []Session
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal sessions: %w", err)
+		}
+		sessions = append(sessions, page...)
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return sessions, nil
+}
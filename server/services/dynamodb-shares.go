@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// CreateFileSharesTable creates the record of which chats each uploaded
+// file has been posted to.
+func CreateFileSharesTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("fileKey"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("chatId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("fileKey"),
+				KeyType:       types.KeyTypeHash, // Partition Key
+			},
+			{
+				AttributeName: aws.String("chatId"),
+				KeyType:       types.KeyTypeRange, // Sort Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the backfill reads messages, which ConnectDB creates first but may
+	// still be building
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	for _, name := range []string{tableName, "messages"} {
+		if err := waiter.Wait(context.TODO(), &dynamodb.DescribeTableInput{
+			TableName: aws.String(name),
+		}, 2*time.Minute); err != nil {
+			return fmt.Errorf("waiting for %s table: %w", name, err)
+		}
+	}
+
+	return BackfillFileShares(client, tableName, "messages")
+}
+
+// BackfillFileShares shares the media of every message with the chat it
+// was posted to, covering messages sent before shares were recorded.
+// Sharing is idempotent, so it's safe to run again over the same messages.
+func BackfillFileShares(client *dynamodb.Client, tableName, messagesTable string) error {
+	var lastEvaluatedKey map[string]types.AttributeValue
+	shared := 0
+
+	for {
+		out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
+			TableName:            aws.String(messagesTable),
+			ProjectionExpression: aws.String("chatId, id, senderId, media, #ts"),
+			ExpressionAttributeNames: map[string]string{
+				"#ts": "timestamp",
+			},
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan messages: %w", err)
+		}
+
+		var page []Message
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return fmt.Errorf("failed to unmarshal messages: %w", err)
+		}
+		for _, msg := range page {
+			if len(msg.Media) == 0 {
+				continue
+			}
+			if err := ShareFiles(client, tableName, msg.ChatID, msg.Media, msg.ID, msg.SenderID, msg.Timestamp); err != nil {
+				return err
+			}
+			shared++
+		}
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	log.Printf("Shared media of %d existing messages\n", shared)
+	return nil
+}
+
+// ShareFiles records each file as posted to the chat by the message with
+// messageID. A share lasts as long as any message still references it.
+func ShareFiles(client *dynamodb.Client, tableName, chatID string, fileKeys []string, messageID, sharedBy string, sharedAt int64) error {
+	for _, key := range fileKeys {
+		_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"fileKey": &types.AttributeValueMemberS{Value: key},
+				"chatId":  &types.AttributeValueMemberS{Value: chatID},
+			},
+			UpdateExpression: aws.String("SET sharedBy = if_not_exists(sharedBy, :u), sharedAt = if_not_exists(sharedAt, :t) ADD messageIds :m"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":u": &types.AttributeValueMemberS{Value: sharedBy},
+				":t": &types.AttributeValueMemberN{Value: fmt.Sprint(sharedAt)},
+				":m": &types.AttributeValueMemberSS{Value: []string{messageID}},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to share file: %w", err)
+		}
+	}
+	return nil
+}
+
+// UnshareFiles drops the message with messageID from each file's share in
+// the chat, removing the share once no message references it.
+func UnshareFiles(client *dynamodb.Client, tableName, chatID string, fileKeys []string, messageID string) error {
+	for _, key := range fileKeys {
+		fileKey := map[string]types.AttributeValue{
+			"fileKey": &types.AttributeValueMemberS{Value: key},
+			"chatId":  &types.AttributeValueMemberS{Value: chatID},
+		}
+		out, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName:           aws.String(tableName),
+			Key:                 fileKey,
+			ConditionExpression: aws.String("contains(messageIds, :id)"),
+			UpdateExpression:    aws.String("DELETE messageIds :m"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":id": &types.AttributeValueMemberS{Value: messageID},
+				":m":  &types.AttributeValueMemberSS{Value: []string{messageID}},
+			},
+			ReturnValues: types.ReturnValueAllNew,
+		})
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			continue // not this message's share
+		}
+		if err != nil {
+			return fmt.Errorf("failed to unshare file: %w", err)
+		}
+		if _, ok := out.Attributes["messageIds"]; ok {
+			continue
+		}
+
+		// DynamoDB drops an emptied set; only remove the share if no
+		// message has shared the file again since
+		_, err = client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+			TableName:           aws.String(tableName),
+			Key:                 fileKey,
+			ConditionExpression: aws.String("attribute_not_exists(messageIds)"),
+		})
+		if err != nil && !errors.As(err, &condErr) {
+			return fmt.Errorf("failed to unshare file: %w", err)
+		}
+	}
+	return nil
+}
+
+// IsFileShared reports whether the file has been posted to the chat.
+func IsFileShared(client *dynamodb.Client, tableName, fileKey, chatID string) (bool, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"fileKey": &types.AttributeValueMemberS{Value: fileKey},
+			"chatId":  &types.AttributeValueMemberS{Value: chatID},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get file share: %w", err)
+	}
+	return out.Item != nil, nil
+}
+
+// GetFileShareChats returns the IDs of every chat the file was posted to.
+func GetFileShareChats(client *dynamodb.Client, tableName, fileKey string) ([]string, error) {
+	var ids []string
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			KeyConditionExpression: aws.String("fileKey = :k"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":k": &types.AttributeValueMemberS{Value: fileKey},
+			},
+			ProjectionExpression: aws.String("chatId"),
+			ExclusiveStartKey:    lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query file shares: %w", err)
+		}
+
+		for _, item := range out.Items {
+			if id, ok := item["chatId"].(*types.AttributeValueMemberS); ok {
+				ids = append(ids, id.Value)
+			}
+		}
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return ids, nil
+}
+
+// OwnsFile reports whether the user uploaded the file stored under fileKey.
+func OwnsFile(client *dynamodb.Client, tableName, userID, fileKey string) (bool, error) {
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			KeyConditionExpression: aws.String("userId = :u"),
+			FilterExpression:       aws.String("fileKey = :k"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":u": &types.AttributeValueMemberS{Value: userID},
+				":k": &types.AttributeValueMemberS{Value: fileKey},
+			},
+			Select:            types.SelectCount,
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to query files: %w", err)
+		}
+		if out.Count > 0 {
+			return true, nil
+		}
+
+		if out.LastEvaluatedKey == nil {
+			return false, nil
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+}
@@ -1,14 +1,23 @@
 package services
 
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
 	ID       string `json:"id" dynamodbav:"id"`
 	Name     string `json:"name" dynamodbav:"name"`
 	Email    string `json:"email" dynamodbav:"email"`
 	Password string `json:"password" dynamodbav:"password"`
+	Role     string `json:"role" dynamodbav:"role"`
+	LastSeen int64  `json:"lastSeen,omitempty" dynamodbav:"lastSeen,omitempty"`
 }
 
 type Chat struct {
 	ID          string   `json:"id" dynamodbav:"id"`
+	Name        string   `json:"name,omitempty" dynamodbav:"name,omitempty"`
 	Users       []string `json:"users" dynamodbav:"users"`
 	Messages    []string `json:"messages" dynamodbav:"messages"`
 	DateCreated int64    `json:"dateCreated" dynamodbav:"dateCreated"`
@@ -22,6 +31,136 @@ type Message struct {
 	Content   string   `json:"content" dynamodbav:"content"`
 	Media     []string `json:"media" dynamodbav:"media"`
 	Timestamp int64    `json:"timestamp" dynamodbav:"timestamp"`
+	ParentID  string   `json:"parentId,omitempty" dynamodbav:"parentId,omitempty"` // set on thread replies
+	Mentions  []string `json:"mentions,omitempty" dynamodbav:"mentions,stringset,omitempty"`
+
+	Quote         *QuotedMessage `json:"quote,omitempty" dynamodbav:"quote,omitempty"`                 // the message being replied to, as it was then
+	ForwardedFrom *ForwardSource `json:"forwardedFrom,omitempty" dynamodbav:"forwardedFrom,omitempty"` // the original of a forwarded message
+
+	Edited    bool     `json:"edited,omitempty" dynamodbav:"edited,omitempty"`
+	EditedAt  int64    `json:"editedAt,omitempty" dynamodbav:"editedAt,omitempty"`
+	Revision  int      `json:"revision,omitempty" dynamodbav:"revision,omitempty"` // number of edits so far
+	DeletedAt int64    `json:"deletedAt,omitempty" dynamodbav:"deletedAt,omitempty"`
+	DeletedBy string   `json:"deletedBy,omitempty" dynamodbav:"deletedBy,omitempty"`
+	Tombstone string   `json:"-" dynamodbav:"tombstone,omitempty"`           // set with deletedAt, keys messagesTombstoneIndex
+	HiddenFor []string `json:"-" dynamodbav:"hiddenFor,stringset,omitempty"` // users who deleted it for themselves only
+
+	ReplyCount  int    `json:"replyCount,omitempty" dynamodbav:"replyCount,omitempty"` // set on thread parents
+	LastReplyID string `json:"lastReplyId,omitempty" dynamodbav:"lastReplyId,omitempty"`
+	LastReplyBy string `json:"lastReplyBy,omitempty" dynamodbav:"lastReplyBy,omitempty"`
+	LastReplyAt int64  `json:"lastReplyAt,omitempty" dynamodbav:"lastReplyAt,omitempty"`
+
+	ReactedBy map[string][]string `json:"-" dynamodbav:"reactions,omitempty"` // emoji -> users who reacted with it
+	Reactions map[string]int      `json:"reactions,omitempty" dynamodbav:"-"` // emoji -> count, filled in for responses
+	Reacted   []string            `json:"reacted,omitempty" dynamodbav:"-"`   // emojis the requesting user reacted with
+}
+
+// QuotedMessage is a snapshot of a quoted message, kept so the quote still
+// reads the same after the original is edited or deleted.
+type QuotedMessage struct {
+	ID        string   `json:"id" dynamodbav:"id"`
+	SenderID  string   `json:"senderId" dynamodbav:"senderId"`
+	Content   string   `json:"content" dynamodbav:"content"`
+	Media     []string `json:"media,omitempty" dynamodbav:"media,omitempty"`
+	Timestamp int64    `json:"timestamp" dynamodbav:"timestamp"`
+}
+
+// ForwardSource credits the original of a forwarded message without
+// revealing which chat it came from.
+type ForwardSource struct {
+	MessageID string `json:"messageId" dynamodbav:"messageId"`
+	SenderID  string `json:"senderId" dynamodbav:"senderId"`
+	Timestamp int64  `json:"timestamp" dynamodbav:"timestamp"`
+}
+
+// Scheduled message statuses. A pending message is claimed as sending when
+// it falls due and removed once sent; one that can't be sent, or whose
+// claim runs out before it is, is kept as failed so its sender can see why.
+const (
+	ScheduledPending = "pending"
+	ScheduledSending = "sending"
+	ScheduledFailed  = "failed"
+)
+
+// ScheduledMessage is a message composed now to be sent at SendAt.
+type ScheduledMessage struct {
+	ChatID    string   `json:"chatId" dynamodbav:"chatId"` // partition key
+	ID        string   `json:"id" dynamodbav:"id"`         // sort key
+	SenderID  string   `json:"senderId" dynamodbav:"senderId"`
+	Content   string   `json:"content" dynamodbav:"content"`
+	Media     []string `json:"media" dynamodbav:"media"`
+	ParentID  string   `json:"parentId,omitempty" dynamodbav:"parentId,omitempty"`
+	QuoteID   string   `json:"quoteId,omitempty" dynamodbav:"quoteId,omitempty"`
+	Mentions  []string `json:"mentions,omitempty" dynamodbav:"mentions,omitempty"`
+	SendAt    int64    `json:"sendAt" dynamodbav:"sendAt"`
+	Status    string   `json:"status" dynamodbav:"status"`
+	Error     string   `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	ClaimedAt int64    `json:"claimedAt,omitempty" dynamodbav:"claimedAt,omitempty"` // when an instance started sending it
+	CreatedAt int64    `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt int64    `json:"updatedAt" dynamodbav:"updatedAt"`
+}
+
+// Mention is an entry in a user's mentions inbox.
+type Mention struct {
+	UserID    string `json:"userId" dynamodbav:"userId"`       // partition key
+	MessageID string `json:"messageId" dynamodbav:"messageId"` // sort key
+	ChatID    string `json:"chatId" dynamodbav:"chatId"`
+	ParentID  string `json:"parentId,omitempty" dynamodbav:"parentId,omitempty"` // set for mentions in a thread
+	SenderID  string `json:"senderId" dynamodbav:"senderId"`
+	Preview   string `json:"preview" dynamodbav:"preview"`
+	Timestamp int64  `json:"timestamp" dynamodbav:"timestamp"`
+}
+
+// MessageRevision is a message's content and media as they were before
+// edit number Revision+1 replaced them.
+type MessageRevision struct {
+	MessageID string   `json:"messageId" dynamodbav:"messageId"` // partition key
+	Revision  int      `json:"revision" dynamodbav:"revision"`   // sort key
+	ChatID    string   `json:"chatId" dynamodbav:"chatId"`
+	Content   string   `json:"content" dynamodbav:"content"`
+	Media     []string `json:"media" dynamodbav:"media"`
+	EditedBy  string   `json:"editedBy" dynamodbav:"editedBy"`
+	EditedAt  int64    `json:"editedAt" dynamodbav:"editedAt"` // when this version was replaced
+}
+
+type ChatMember struct {
+	UserID       string `json:"userId" dynamodbav:"userId"` // partition key
+	ChatID       string `json:"chatId" dynamodbav:"chatId"` // sort key
+	LastActivity int64  `json:"lastActivity" dynamodbav:"lastActivity"`
+}
+
+type ReadCursor struct {
+	ChatID            string `json:"chatId" dynamodbav:"chatId"` // partition key
+	UserID            string `json:"userId" dynamodbav:"userId"` // sort key
+	LastReadMessageID string `json:"lastReadMessageId" dynamodbav:"lastReadMessageId"`
+	LastReadAt        int64  `json:"lastReadAt" dynamodbav:"lastReadAt"` // timestamp of the last read message
+	UpdatedAt         int64  `json:"updatedAt" dynamodbav:"updatedAt"`
+}
+
+// Session is one login of a user. Each refresh rotates TokenID, the ID of
+// the only refresh token still accepted for the session.
+type Session struct {
+	ID         string `json:"id" dynamodbav:"id"`
+	UserID     string `json:"userId" dynamodbav:"userId"`
+	TokenID    string `json:"-" dynamodbav:"tokenId"`
+	Revoked    bool   `json:"revoked" dynamodbav:"revoked"`
+	DeviceName string `json:"deviceName" dynamodbav:"deviceName"`
+	UserAgent  string `json:"userAgent" dynamodbav:"userAgent"`
+	IP         string `json:"ip" dynamodbav:"ip"`
+	CreatedAt  int64  `json:"createdAt" dynamodbav:"createdAt"`
+	LastUsed   int64  `json:"lastUsed" dynamodbav:"lastUsed"`
+	ExpiresAt  int64  `json:"expiresAt" dynamodbav:"expiresAt"`
+}
+
+// FileShare records that a file was posted to a chat, which lets the
+// chat's members download it.
+type FileShare struct {
+	FileKey  string `json:"fileKey" dynamodbav:"fileKey"` // partition key
+	ChatID   string `json:"chatId" dynamodbav:"chatId"`   // sort key
+	SharedBy string `json:"sharedBy" dynamodbav:"sharedBy"`
+	SharedAt int64  `json:"sharedAt" dynamodbav:"sharedAt"`
+
+	MessageIDs []string `json:"-" dynamodbav:"messageIds,stringset,omitempty"` // messages that posted the file here
 }
 
 type UserFile struct {
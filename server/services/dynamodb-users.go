@@ -250,6 +250,56 @@ func UpdatePassword(client *dynamodb.Client, tableName string, user User) error
 	return err
 }
 
+func UpdateUserRole(client *dynamodb.Client, tableName, id, role string) error {
+	expr, err := expression.NewBuilder().
+		WithUpdate(expression.Set(expression.Name("role"), expression.Value(role))).
+		WithCondition(expression.AttributeExists(expression.Name("id"))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("error in expression builder: %w", err)
+	}
+
+	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	return nil
+}
+
+func UpdateLastSeen(client *dynamodb.Client, tableName, id string, lastSeen int64) error {
+	expr, err := expression.NewBuilder().
+		WithUpdate(expression.Set(expression.Name("lastSeen"), expression.Value(lastSeen))).
+		WithCondition(expression.AttributeExists(expression.Name("id"))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("error in expression builder: %w", err)
+	}
+
+	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update lastSeen: %w", err)
+	}
+	return nil
+}
+
 func DeleteUser(client *dynamodb.Client, tableName, id string) error {
 	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
 		TableName: aws.String(tableName),
@@ -34,20 +34,36 @@ func ConnectDB() *dynamodb.Client {
 	)
 	ddbClient := dynamodb.NewFromConfig(ddbCfg)
 
-	tables := map[string]func(*dynamodb.Client, string) error{
-		"chats":    CreateChatsTable,
-		"messages": CreateMessagesTable,
-		"users":    CreateUsersTable,
-		"files":    CreateFilesTable,
+	// in order: some tables are filled from ones listed before them
+	tables := []struct {
+		name   string
+		create func(*dynamodb.Client, string) error
+	}{
+		{"chats", CreateChatsTable},
+		{"messages", CreateMessagesTable},
+		{"users", CreateUsersTable},
+		{"files", CreateFilesTable},
+		{"chat_reads", CreateChatReadsTable},
+		{"sessions", CreateSessionsTable},
+		{"chat_members", CreateChatMembersTable},
+		{"message_revisions", CreateMessageRevisionsTable},
+		{"file_shares", CreateFileSharesTable},
+		{"mentions", CreateMentionsTable},
+		{"scheduled_messages", CreateScheduledMessagesTable},
+		{"locks", CreateLocksTable},
 	}
 
 	// Loop through tables
-	for name, createFunc := range tables {
-		err := CreateTableIfNotExists(createFunc, ddbClient, name)
+	for _, table := range tables {
+		err := CreateTableIfNotExists(table.create, ddbClient, table.name)
 		if err != nil {
-			log.Fatalf("failed to create/check table %s: %v", name, err)
+			log.Fatalf("failed to create/check table %s: %v", table.name, err)
 		}
-		log.Printf("%s table read for data\n", name)
+		log.Printf("%s table read for data\n", table.name)
+	}
+
+	if err := EnsureMessageIndexes(ddbClient, "messages"); err != nil {
+		log.Fatalf("failed to index messages table: %v", err)
 	}
 
 	log.Printf("Connected to DynamoDB\n")
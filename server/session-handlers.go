@@ -0,0 +1,127 @@
+package server
+
+import (
+	"fluffy-coto-tribble/server/services"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionView is a session as listed to its owner.
+type SessionView struct {
+	services.Session
+	Current bool `json:"current"`
+}
+
+func GetSessions(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := currentClaims(c)
+
+		sessions, err := activeSessions(client, claims.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		views := make([]SessionView, 0, len(sessions))
+		for _, s := range sessions {
+			views = append(views, SessionView{Session: s, Current: s.ID == claims.SessionID})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"sessions": views})
+	}
+}
+
+func DeleteSession(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := currentClaims(c)
+		sessionID := c.Param("id")
+
+		session, err := services.GetSession(client, "sessions", sessionID)
+		if err != nil || session.UserID != claims.ID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+
+		if err := services.RevokeSession(client, "sessions", session.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		hub.closeSessions(session.ID)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+	}
+}
+
+// DeleteAllSessions logs the caller out everywhere, including this device.
+func DeleteAllSessions(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revoked, err := revokeUserSessions(client, hub, currentClaims(c).ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "All sessions revoked",
+			"revoked": revoked,
+		})
+	}
+}
+
+// Logout revokes the session the caller's access token belongs to.
+func Logout(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := currentClaims(c)
+		if claims.SessionID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Token is not tied to a session"})
+			return
+		}
+
+		if err := services.RevokeSession(client, "sessions", claims.SessionID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+			return
+		}
+		hub.closeSessions(claims.SessionID)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+	}
+}
+
+// revokeUserSessions revokes every active session of the user and closes
+// their sockets, returning how many it revoked. Sessions revoked before a
+// failure still lose their sockets.
+func revokeUserSessions(client *dynamodb.Client, hub *Hub, userID string) (int, error) {
+	sessions, err := activeSessions(client, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := make([]string, 0, len(sessions))
+	defer func() { hub.closeSessions(revoked...) }()
+	for _, s := range sessions {
+		if err := services.RevokeSession(client, "sessions", s.ID); err != nil {
+			return len(revoked), err
+		}
+		revoked = append(revoked, s.ID)
+	}
+	return len(revoked), nil
+}
+
+func activeSessions(client *dynamodb.Client, userID string) ([]services.Session, error) {
+	sessions, err := services.GetUserSessions(client, "sessions", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	active := sessions[:0]
+	for _, s := range sessions {
+		if !s.Revoked && s.ExpiresAt > now {
+			active = append(active, s)
+		}
+	}
+	return active, nil
+}
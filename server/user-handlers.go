@@ -1,29 +1,21 @@
 package server
 
 import (
-	"encoding/base64"
 	"fluffy-coto-tribble/server/authentication"
 	"fluffy-coto-tribble/server/services"
-	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
-	"github.com/google/uuid"
 )
 
-func ShortUUID() string {
-	u := uuid.New()
-	return strings.TrimRight(
-		strings.NewReplacer("-", "", "_", "", "/", "").Replace(
-			base64.URLEncoding.EncodeToString(u[:])),
-		"=",
-	)
+// currentClaims returns the claims AuthMiddleware stored on the request.
+func currentClaims(c *gin.Context) *authentication.UserClaims {
+	claims, _ := c.MustGet("claims").(*authentication.UserClaims)
+	return claims
 }
 
 func CreateUser(client *dynamodb.Client) gin.HandlerFunc {
@@ -34,10 +26,8 @@ func CreateUser(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
-		id := ShortUUID()
-
 		email := strings.ToLower(user.Email)
-		userId := fmt.Sprintf("u_%s", id)
+		userId := NewID("u")
 
 		hashedPassword, err := authentication.HashedPassword(user.Password)
 		if err != nil {
@@ -50,6 +40,7 @@ func CreateUser(client *dynamodb.Client) gin.HandlerFunc {
 			"name":     &types.AttributeValueMemberS{Value: user.Name},
 			"email":    &types.AttributeValueMemberS{Value: email},
 			"password": &types.AttributeValueMemberS{Value: hashedPassword},
+			"role":     &types.AttributeValueMemberS{Value: services.RoleUser},
 		}
 
 		if err := services.CreateUser(client, "users", newUser); err != nil {
@@ -57,32 +48,14 @@ func CreateUser(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
-		claims := authentication.UserClaims{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     email,
-			TokenType: "access",
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(authentication.AccessTokenTTL).Unix(),
-				IssuedAt:  time.Now().Unix(),
-				Subject:   user.ID,
-			},
-		}
-
-		accessToken, err := authentication.NewAccessToken(claims)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access token"})
-			return
-		}
-
-		refreshClaims := jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(authentication.RefreshTokenTTL).Unix(),
-			IssuedAt:  time.Now().Unix(),
-			Subject:   user.ID,
-		}
-		refreshToken, err := authentication.NewRefreshToken(refreshClaims)
+		accessToken, refreshToken, err := authentication.StartSession(client, c, services.User{
+			ID:    userId,
+			Name:  user.Name,
+			Email: email,
+			Role:  services.RoleUser,
+		})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refresh token"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start session"})
 			return
 		}
 
@@ -107,7 +80,7 @@ func AuthUser(client *dynamodb.Client) gin.HandlerFunc {
 		}
 
 		user, err := services.GetUserByEmail(client, "users", req.Email)
-		if err != nil {
+		if err != nil || user == nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "No user found with that email."})
 			return
 		}
@@ -117,32 +90,9 @@ func AuthUser(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
-		claims := authentication.UserClaims{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     user.Email,
-			TokenType: "access",
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(authentication.AccessTokenTTL).Unix(),
-				IssuedAt:  time.Now().Unix(),
-				Subject:   user.ID,
-			},
-		}
-
-		accessToken, err := authentication.NewAccessToken(claims)
+		accessToken, refreshToken, err := authentication.StartSession(client, c, *user)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access token"})
-			return
-		}
-
-		refreshClaims := jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(authentication.RefreshTokenTTL).Unix(),
-			IssuedAt:  time.Now().Unix(),
-			Subject:   user.ID,
-		}
-		refreshToken, err := authentication.NewRefreshToken(refreshClaims)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refresh token"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start session"})
 			return
 		}
 
@@ -221,11 +171,7 @@ func GetUserByID(client *dynamodb.Client) gin.HandlerFunc {
 
 func UpdateUser(client *dynamodb.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
-		if token == "" || authentication.ParseAccessToken(token) == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
-			return
-		}
+		claims := currentClaims(c)
 
 		var user services.User
 		if err := c.ShouldBindJSON(&user); err != nil {
@@ -233,6 +179,14 @@ func UpdateUser(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
+		if user.ID == "" {
+			user.ID = claims.ID
+		}
+		if !claims.CanActFor(user.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only update your own account"})
+			return
+		}
+
 		if err := services.UpdateUser(client, "users", user); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 			return
@@ -244,11 +198,7 @@ func UpdateUser(client *dynamodb.Client) gin.HandlerFunc {
 
 func UpdatePassword(client *dynamodb.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
-		if token == "" || authentication.ParseAccessToken(token) == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
-			return
-		}
+		claims := currentClaims(c)
 
 		var user services.User
 		if err := c.ShouldBindJSON(&user); err != nil {
@@ -256,6 +206,14 @@ func UpdatePassword(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
+		if user.ID == "" {
+			user.ID = claims.ID
+		}
+		if !claims.CanActFor(user.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only update your own account"})
+			return
+		}
+
 		hashedPassword, err := authentication.HashedPassword(user.Password)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
@@ -272,13 +230,19 @@ func UpdatePassword(client *dynamodb.Client) gin.HandlerFunc {
 	}
 }
 
-func DeleteUser(client *dynamodb.Client) gin.HandlerFunc {
+// DeleteUser deletes an account after revoking its sessions, which also
+// drops its open sockets.
+func DeleteUser(client *dynamodb.Client, hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 
-		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
-		if token == "" || authentication.ParseAccessToken(token) == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
+		if !currentClaims(c).CanActFor(id) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own account"})
+			return
+		}
+
+		if _, err := revokeUserSessions(client, hub, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
 			return
 		}
 
@@ -290,3 +254,61 @@ func DeleteUser(client *dynamodb.Client) gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"message": "User Deleted!"})
 	}
 }
+
+func GetUserPresence(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		presence := Presence{UserID: id, Status: hub.presence.status(id)}
+		if presence.Status == PresenceOffline {
+			resp, err := services.GetUserById(hub.db, "users", id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+				return
+			}
+			if resp == nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+
+			var user services.User
+			if err := attributevalue.UnmarshalMap(resp, &user); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode user"})
+				return
+			}
+			presence.LastSeen = user.LastSeen
+		}
+
+		c.JSON(http.StatusOK, gin.H{"presence": presence})
+	}
+}
+
+// SetUserRole lets an admin promote or demote a user. The new role is
+// picked up the next time the user's access token is refreshed.
+func SetUserRole(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var req struct {
+			Role string `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		switch req.Role {
+		case services.RoleUser, services.RoleModerator, services.RoleAdmin:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+			return
+		}
+
+		if err := services.UpdateUserRole(client, "users", id, req.Role); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Failed to update role"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "User Role Updated!", "role": req.Role})
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"encoding/json"
+	"fluffy-coto-tribble/server/authentication"
+	"fluffy-coto-tribble/server/services"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// browsers can't set headers on a websocket handshake, so the token may also
+// be offered as the second entry of Sec-WebSocket-Protocol: ["access_token", "<jwt>"]
+const wsTokenSubprotocol = "access_token"
+
+type Client struct {
+	conn    *websocket.Conn
+	send    chan []byte
+	claims  *authentication.UserClaims
+	expiry  *time.Timer
+	rooms   map[string]bool // owned by the hub goroutine
+	evicted atomic.Bool     // set by the hub before it closes send on a slow consumer
+	signals signalLimiter   // owned by the read goroutine
+	paused  bool            // owned by the hub goroutine, set while a resume replay is loading
+	held    [][]byte        // owned by the hub goroutine, frames queued while paused
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		// allow all origins TESTING ONLY
+		return true
+	},
+}
+
+// wsToken pulls the access token from the Authorization header, the
+// Sec-WebSocket-Protocol header or the "token" query param, in that order.
+// The returned subprotocol must be echoed back on upgrade when non-empty.
+func wsToken(r *http.Request) (token string, subprotocol string) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		if t := strings.TrimPrefix(authHeader, "Bearer "); t != authHeader {
+			return t, ""
+		}
+	}
+
+	protocols := websocket.Subprotocols(r)
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == wsTokenSubprotocol {
+			return protocols[i+1], wsTokenSubprotocol
+		}
+	}
+
+	return r.URL.Query().Get("token"), ""
+}
+
+func serveWs(hub *Hub, c *gin.Context) {
+	token, subprotocol := wsToken(c.Request)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Access token missing"})
+		return
+	}
+
+	claims := authentication.ParseAccessToken(token)
+	if claims == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
+		return
+	}
+	if claims.TokenType != "access" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh tokens cannot be used here"})
+		return
+	}
+	if claims.SessionID != "" {
+		session, err := services.GetSession(hub.db, "sessions", claims.SessionID)
+		if err != nil || session.Revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			return
+		}
+	}
+
+	var header http.Header
+	if subprotocol != "" {
+		header = http.Header{"Sec-WebSocket-Protocol": {subprotocol}}
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, header)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	client := &Client{
+		conn:   conn,
+		send:   make(chan []byte, WSSendBuffer),
+		claims: claims,
+		rooms:  make(map[string]bool),
+	}
+
+	// drop the socket as soon as the access token it was opened with expires
+	if claims.ExpiresAt > 0 {
+		ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
+		client.expiry = time.AfterFunc(ttl, func() {
+			client.closeWith(websocket.ClosePolicyViolation, "token expired")
+		})
+	}
+
+	hub.register <- client
+
+	go client.write()
+	go client.read(hub)
+}
+
+// closeWith sends a close frame with the given code and tears down the
+// connection, which in turn ends the read loop and unregisters the client.
+func (c *Client) closeWith(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		log.Println("Close error:", err)
+	}
+	c.conn.Close()
+}
+
+func (c *Client) read(hub *Hub) {
+	defer func() {
+		if c.expiry != nil {
+			c.expiry.Stop()
+		}
+		hub.unregister <- c
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(WSMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(WSPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(WSPongWait))
+	})
+
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("Read error:", err)
+			}
+			break
+		}
+
+		var env WSEnvelope
+		if err := json.Unmarshal(msg, &env); err != nil {
+			c.fail(hub, "", wsErr(ErrCodeBadRequest, "malformed JSON"))
+			continue
+		}
+
+		log.Printf("{ User: %s, Type: %s, Chat: %s, ID: %s }\n", c.claims.ID, env.Type, env.ChatID, env.ID)
+
+		c.dispatch(hub, env)
+	}
+}
+
+// dispatch routes a client frame to its registered handler, answering with
+// an error frame if the frame can't be handled.
+func (c *Client) dispatch(hub *Hub, env WSEnvelope) {
+	if env.Version != WSProtocolVersion {
+		c.fail(hub, env.ID, wsErr(ErrCodeUnsupportedVersion,
+			fmt.Sprintf("protocol version %d is not supported, use %d", env.Version, WSProtocolVersion)))
+		return
+	}
+
+	handler, ok := wsHandlers[env.Type]
+	if !ok {
+		c.fail(hub, env.ID, wsErr(ErrCodeUnknownType, fmt.Sprintf("unknown event type %q", env.Type)))
+		return
+	}
+
+	if err := handler(c, hub, env); err != nil {
+		c.fail(hub, env.ID, err)
+	}
+}
+
+func (c *Client) ack(hub *Hub, env WSEnvelope, data interface{}) {
+	hub.sendTo(c, newEvent(EventAck, env.ChatID, env.ID, WSAck{Type: env.Type, Data: data}))
+}
+
+func (c *Client) fail(hub *Hub, id string, err error) {
+	hub.sendTo(c, newEvent(EventError, "", id, asWSError(err)))
+}
+
+// write drains the send queue and keeps the connection alive with pings.
+// When the hub closes send the peer gets a close frame explaining why.
+func (c *Client) write() {
+	ticker := time.NewTicker(WSPingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(WSWriteWait))
+			if !ok {
+				code, reason := websocket.CloseNormalClosure, ""
+				if c.evicted.Load() {
+					code, reason = websocket.CloseTryAgainLater, "slow consumer"
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Println("Write error:", err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(WSWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Println("Ping error:", err)
+				return
+			}
+		}
+	}
+}
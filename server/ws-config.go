@@ -0,0 +1,100 @@
+package server
+
+import (
+	"expvar"
+	"fluffy-coto-tribble/server/broker"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	WSWriteWait      = 10 * time.Second // time allowed to write a frame
+	WSPongWait       = 60 * time.Second // time allowed between pongs before the peer is considered dead
+	WSPingInterval   = 54 * time.Second // must be shorter than WSPongWait
+	WSMaxMessageSize = int64(64 * 1024) // largest frame accepted from a client
+	WSSendBuffer     = 256              // frames queued per client before it's evicted as a slow consumer
+	WSTypingTimeout  = 6 * time.Second  // typing.stopped is sent for a client that goes quiet this long
+	WSSignalRate     = 2.0              // ephemeral signals per second a client may sustain
+	WSSignalBurst    = 5                // ephemeral signals a client may send at once
+	WSReplayLimit    = 500              // most messages replayed per chat on resume
+
+	WSPresenceHeartbeat = 15 * time.Second // how often instances share their presence; one silent for three is forgotten
+)
+
+var (
+	wsConnectedClients = expvar.NewInt("ws_connected_clients")
+	wsDroppedClients   = expvar.NewInt("ws_dropped_clients")
+)
+
+// LoadWSConfig overrides the WebSocket defaults from WS_WRITE_WAIT,
+// WS_PONG_WAIT, WS_PING_INTERVAL (durations like "30s"), WS_MAX_MESSAGE_SIZE
+// (bytes), WS_SEND_BUFFER (frames), WS_TYPING_TIMEOUT (duration),
+// WS_SIGNAL_BURST (signals), WS_REPLAY_LIMIT (messages) and
+// WS_PRESENCE_HEARTBEAT (duration).
+func LoadWSConfig() {
+	WSWriteWait = envDuration("WS_WRITE_WAIT", WSWriteWait)
+	WSPongWait = envDuration("WS_PONG_WAIT", WSPongWait)
+	WSPingInterval = envDuration("WS_PING_INTERVAL", WSPingInterval)
+	WSMaxMessageSize = int64(envInt("WS_MAX_MESSAGE_SIZE", int(WSMaxMessageSize)))
+	WSSendBuffer = envInt("WS_SEND_BUFFER", WSSendBuffer)
+	WSTypingTimeout = envDuration("WS_TYPING_TIMEOUT", WSTypingTimeout)
+	WSSignalBurst = envInt("WS_SIGNAL_BURST", WSSignalBurst)
+	WSReplayLimit = envInt("WS_REPLAY_LIMIT", WSReplayLimit)
+	WSPresenceHeartbeat = envDuration("WS_PRESENCE_HEARTBEAT", WSPresenceHeartbeat)
+
+	if WSPingInterval >= WSPongWait {
+		WSPingInterval = (WSPongWait * 9) / 10
+		log.Printf("WS_PING_INTERVAL must be shorter than WS_PONG_WAIT, using %s\n", WSPingInterval)
+	}
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %s\n", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s %q, using %d\n", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+// NewHubBroker picks the hub's fan-out backend: Redis pub/sub when REDIS_URL
+// is set (on channel WS_BROKER_CHANNEL), otherwise an in-memory broker that
+// only serves this instance.
+func NewHubBroker() broker.Broker {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		log.Println("REDIS_URL not set, WebSocket fan-out is local to this instance")
+		return broker.NewMemoryBroker()
+	}
+
+	channel := os.Getenv("WS_BROKER_CHANNEL")
+	if channel == "" {
+		channel = "fluffy-octo-tribble:hub"
+	}
+
+	b, err := broker.NewRedisBroker(redisURL, channel)
+	if err != nil {
+		log.Fatalf("failed to connect WebSocket broker: %v", err)
+	}
+	log.Printf("Connected to Redis broker on channel %s\n", channel)
+	return b
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"errors"
+	"fluffy-coto-tribble/server/services"
+	"slices"
+	"strings"
+)
+
+// wsHandler handles one client event type. Returning an error sends an
+// error frame correlated with the request; handlers ack on their own.
+type wsHandler func(c *Client, hub *Hub, env WSEnvelope) error
+
+// wsHandlers is the registry of event types clients may send.
+var wsHandlers = map[string]wsHandler{
+	EventSubscribe:      handleSubscribe,
+	EventUnsubscribe:    handleUnsubscribe,
+	EventMessageCreate:  handleMessageCreate,
+	EventMessageUpdate:  handleMessageEdit,
+	EventMessageEdit:    handleMessageEdit,
+	EventMessageDelete:  handleMessageDelete,
+	EventMessageForward: handleMessageForward,
+	EventReactionAdd:    handleReaction(true),
+	EventReactionRemove: handleReaction(false),
+	EventPresenceSet:    handlePresenceSet,
+	EventTypingStart:    handleTypingStart,
+	EventTypingStop:     handleTypingStop,
+	EventReadMark:       handleReadMark,
+	EventResume:         handleResume,
+}
+
+type subscribePayload struct {
+	ThreadID string `json:"threadId"` // subscribe to one thread instead of the whole chat
+}
+
+type messageForwardPayload struct {
+	ID       string `json:"id"`
+	ToChatID string `json:"toChatId"`
+}
+
+type messageEditPayload struct {
+	ID string `json:"id"`
+	messageEdit
+}
+
+type messageDeletePayload struct {
+	ID   string `json:"id"`
+	Mode string `json:"mode"` // "me" or "everyone", the default
+}
+
+type reactionPayload struct {
+	ID    string `json:"id"`
+	Emoji string `json:"emoji"`
+}
+
+type readMarkPayload struct {
+	MessageID string `json:"messageId"`
+}
+
+type presenceSetPayload struct {
+	Status string `json:"status"`
+}
+
+func handleSubscribe(c *Client, hub *Hub, env WSEnvelope) error {
+	room, err := subscriptionRoom(env)
+	if err != nil {
+		return err
+	}
+	if _, err := c.chatFor(hub, env.ChatID); err != nil {
+		return err
+	}
+	hub.subscribe <- subscription{client: c, chatID: room}
+	c.ack(hub, env, nil)
+	return nil
+}
+
+func handleUnsubscribe(c *Client, hub *Hub, env WSEnvelope) error {
+	room, err := subscriptionRoom(env)
+	if err != nil {
+		return err
+	}
+	hub.unsubscribe <- subscription{client: c, chatID: room}
+	c.ack(hub, env, nil)
+	return nil
+}
+
+// subscriptionRoom picks the chat's room, or one of its threads' when the
+// payload names a threadId.
+func subscriptionRoom(env WSEnvelope) (string, error) {
+	var body subscribePayload
+	if len(env.Payload) > 0 {
+		if err := decodePayload(env, &body); err != nil {
+			return "", err
+		}
+	}
+	if body.ThreadID == "" {
+		return env.ChatID, nil
+	}
+	if strings.Contains(body.ThreadID, "/") {
+		return "", wsErr(ErrCodeBadRequest, "invalid threadId")
+	}
+	return threadRoom(env.ChatID, body.ThreadID), nil
+}
+
+func handleMessageCreate(c *Client, hub *Hub, env WSEnvelope) error {
+	var body messageCreate
+	if err := decodePayload(env, &body); err != nil {
+		return err
+	}
+	chat, err := c.chatFor(hub, env.ChatID)
+	if err != nil {
+		return err
+	}
+
+	body.ChatID = env.ChatID
+	msg, err := saveMessage(hub.db, hub, chat, body.newMessage(c.claims.ID))
+	if err != nil {
+		return saveWSError(err)
+	}
+
+	c.ack(hub, env, msg)
+	return nil
+}
+
+func handleMessageForward(c *Client, hub *Hub, env WSEnvelope) error {
+	var body messageForwardPayload
+	if err := decodePayload(env, &body); err != nil {
+		return err
+	}
+	if body.ID == "" || body.ToChatID == "" {
+		return wsErr(ErrCodeBadRequest, "id and toChatId are required")
+	}
+	if _, err := c.chatFor(hub, env.ChatID); err != nil {
+		return err
+	}
+	dest, err := c.chatFor(hub, body.ToChatID)
+	if err != nil {
+		return err
+	}
+
+	msg, err := services.GetChatMessage(hub.db, "messages", env.ChatID, body.ID)
+	if err != nil || messageView(*msg, c.claims) == nil {
+		return wsErr(ErrCodeNotFound, "message not found")
+	}
+
+	forwarded, err := forwardMessage(hub.db, hub, *msg, dest, c.claims.ID)
+	if errors.Is(err, services.ErrMessageDeleted) {
+		return wsErr(ErrCodeNotFound, err.Error())
+	}
+	if err != nil {
+		return saveWSError(err)
+	}
+
+	c.ack(hub, env, forwarded)
+	return nil
+}
+
+// saveWSError maps an error from saveMessage onto an error frame.
+func saveWSError(err error) error {
+	switch {
+	case errors.Is(err, errInvalidParent), errors.Is(err, errInvalidQuote), errors.Is(err, errInvalidMention):
+		return wsErr(ErrCodeBadRequest, err.Error())
+	case errors.Is(err, errMediaForbidden):
+		return wsErr(ErrCodeForbidden, err.Error())
+	}
+	return err
+}
+
+func handleMessageEdit(c *Client, hub *Hub, env WSEnvelope) error {
+	var body messageEditPayload
+	if err := decodePayload(env, &body); err != nil {
+		return err
+	}
+	chat, msg, err := c.ownMessage(hub, env.ChatID, body.ID)
+	if err != nil {
+		return err
+	}
+
+	content, media := msg.Content, msg.Media
+	if body.Content != nil {
+		content = *body.Content
+	}
+	if body.Media != nil {
+		media = *body.Media
+	}
+	if content == "" && len(media) == 0 {
+		return wsErr(ErrCodeBadRequest, "a message needs content or media")
+	}
+
+	edited, err := editMessage(hub.db, hub, chat, *msg, content, media, body.mentions())
+	if errors.Is(err, errInvalidMention) {
+		return wsErr(ErrCodeBadRequest, err.Error())
+	}
+	if errors.Is(err, errMediaForbidden) {
+		return wsErr(ErrCodeForbidden, err.Error())
+	}
+	if errors.Is(err, services.ErrMessageDeleted) {
+		return wsErr(ErrCodeNotFound, err.Error())
+	}
+	if errors.Is(err, services.ErrMessageChanged) {
+		return wsErr(ErrCodeConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	c.ack(hub, env, edited)
+	return nil
+}
+
+func handleMessageDelete(c *Client, hub *Hub, env WSEnvelope) error {
+	var body messageDeletePayload
+	if err := decodePayload(env, &body); err != nil {
+		return err
+	}
+	if body.Mode == "" {
+		body.Mode = DeleteForEveryone
+	}
+	if body.Mode != DeleteForMe && body.Mode != DeleteForEveryone {
+		return wsErr(ErrCodeBadRequest, "mode must be me or everyone")
+	}
+	if body.ID == "" {
+		return wsErr(ErrCodeBadRequest, "message id is required")
+	}
+
+	chat, err := c.chatFor(hub, env.ChatID)
+	if err != nil {
+		return err
+	}
+	msg, err := services.GetChatMessage(hub.db, "messages", chat.ID, body.ID)
+	if err != nil {
+		return wsErr(ErrCodeNotFound, err.Error())
+	}
+	if body.Mode == DeleteForEveryone && !c.claims.CanActFor(msg.SenderID) && !c.claims.HasRole(services.RoleModerator) {
+		return wsErr(ErrCodeForbidden, "only the sender or a moderator can delete this message")
+	}
+
+	deleted, err := deleteMessage(hub.db, hub, chat, *msg, c.claims.ID, body.Mode)
+	if errors.Is(err, services.ErrMessageDeleted) {
+		return wsErr(ErrCodeNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	c.ack(hub, env, deleted)
+	return nil
+}
+
+// handleReaction builds the handler for reaction.add or, when add is false,
+// reaction.remove.
+func handleReaction(add bool) wsHandler {
+	return func(c *Client, hub *Hub, env WSEnvelope) error {
+		var body reactionPayload
+		if err := decodePayload(env, &body); err != nil {
+			return err
+		}
+		if body.ID == "" {
+			return wsErr(ErrCodeBadRequest, "message id is required")
+		}
+		if !validReaction(body.Emoji) {
+			return wsErr(ErrCodeBadRequest, "invalid reaction")
+		}
+
+		chat, err := c.chatFor(hub, env.ChatID)
+		if err != nil {
+			return err
+		}
+		msg, err := services.GetChatMessage(hub.db, "messages", chat.ID, body.ID)
+		if err != nil || messageView(*msg, c.claims) == nil {
+			return wsErr(ErrCodeNotFound, "message not found")
+		}
+
+		update, err := react(hub.db, hub, chat, msg.ID, body.Emoji, c.claims.ID, add)
+		if errors.Is(err, services.ErrMessageDeleted) {
+			return wsErr(ErrCodeNotFound, err.Error())
+		}
+		if errors.Is(err, services.ErrTooManyReactions) {
+			return wsErr(ErrCodeConflict, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+
+		c.ack(hub, env, update)
+		return nil
+	}
+}
+
+func handlePresenceSet(c *Client, hub *Hub, env WSEnvelope) error {
+	var body presenceSetPayload
+	if err := decodePayload(env, &body); err != nil {
+		return err
+	}
+	if body.Status != PresenceOnline && body.Status != PresenceAway {
+		return wsErr(ErrCodeBadRequest, "status must be online or away")
+	}
+
+	hub.setPresence(c, body.Status)
+	c.ack(hub, env, nil)
+	return nil
+}
+
+func handleReadMark(c *Client, hub *Hub, env WSEnvelope) error {
+	var body readMarkPayload
+	if len(env.Payload) > 0 {
+		if err := decodePayload(env, &body); err != nil {
+			return err
+		}
+	}
+	chat, err := c.chatFor(hub, env.ChatID)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := markRead(hub.db, hub, chat, c.claims.ID, body.MessageID)
+	if err != nil {
+		return err
+	}
+
+	c.ack(hub, env, cursor)
+	return nil
+}
+
+// chatFor loads the chat and confirms the client's user is one of its members.
+func (c *Client) chatFor(hub *Hub, chatID string) (*services.Chat, error) {
+	if chatID == "" {
+		return nil, wsErr(ErrCodeBadRequest, "chatId is required")
+	}
+	chat, err := services.GetChatById(hub.db, "chats", chatID)
+	if err != nil {
+		return nil, wsErr(ErrCodeNotFound, err.Error())
+	}
+	if !slices.Contains(chat.Users, c.claims.ID) {
+		return nil, wsErr(ErrCodeForbidden, "not a member of this chat")
+	}
+	return chat, nil
+}
+
+// ownMessage loads a message the client's user sent in a chat they belong
+// to, along with the chat.
+func (c *Client) ownMessage(hub *Hub, chatID, msgID string) (*services.Chat, *services.Message, error) {
+	if msgID == "" {
+		return nil, nil, wsErr(ErrCodeBadRequest, "message id is required")
+	}
+	chat, err := c.chatFor(hub, chatID)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := services.GetChatMessage(hub.db, "messages", chatID, msgID)
+	if err != nil {
+		return nil, nil, wsErr(ErrCodeNotFound, err.Error())
+	}
+	if msg.SenderID != c.claims.ID {
+		return nil, nil, wsErr(ErrCodeForbidden, "only the sender can edit this message")
+	}
+	return chat, msg, nil
+}
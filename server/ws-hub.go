@@ -0,0 +1,344 @@
+package server
+
+import (
+	"encoding/json"
+	"fluffy-coto-tribble/server/broker"
+	"fluffy-coto-tribble/server/services"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gorilla/websocket"
+)
+
+type Hub struct {
+	db          *dynamodb.Client
+	broker      broker.Broker
+	instance    string // names this instance in presence reports
+	clients     map[*Client]bool
+	users       map[string]map[*Client]bool // user ID -> connections
+	rooms       map[string]map[*Client]bool // chat ID -> subscribed clients
+	register    chan *Client
+	unregister  chan *Client
+	subscribe   chan subscription
+	unsubscribe chan subscription
+	deliver     chan delivery
+	pause       chan *Client
+	resume      chan replay
+	presence    *presenceTracker
+	typing      *typingTracker
+}
+
+type subscription struct {
+	client *Client
+	chatID string
+}
+
+// delivery is a frame headed to a single client, to the subscribers of a
+// chat room whose user ID is in members, or, with no chatID, to every
+// connection of the users in members. A delivery with closeSessions instead
+// disconnects every client opened under one of those sessions.
+type delivery struct {
+	client        *Client
+	chatID        string
+	members       map[string]bool
+	data          []byte
+	closeSessions []string
+}
+
+// wireDelivery is a fan-out delivery as it travels through the broker.
+// Single-client deliveries never leave the instance holding the client.
+// Presence reports and search index changes travel the same way, in place
+// of a delivery.
+type wireDelivery struct {
+	ChatID        string          `json:"chatId,omitempty"`
+	Members       []string        `json:"members"`
+	Data          json.RawMessage `json:"data,omitempty"`
+	CloseSessions []string        `json:"closeSessions,omitempty"`
+	Presence      *presenceReport `json:"presence,omitempty"`
+	Search        *searchUpdate   `json:"search,omitempty"`
+}
+
+func newHub(db *dynamodb.Client, b broker.Broker) *Hub {
+	instance := NewID("i")
+	return &Hub{
+		db:          db,
+		broker:      b,
+		instance:    instance,
+		clients:     make(map[*Client]bool),
+		users:       make(map[string]map[*Client]bool),
+		rooms:       make(map[string]map[*Client]bool),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		subscribe:   make(chan subscription),
+		unsubscribe: make(chan subscription),
+		deliver:     make(chan delivery),
+		pause:       make(chan *Client),
+		resume:      make(chan replay),
+		presence:    newPresenceTracker(instance),
+		typing:      newTypingTracker(),
+	}
+}
+
+func (h *Hub) run() {
+	log.Println("WebSocket server listening on /ws")
+
+	if err := h.broker.Subscribe(h.receive); err != nil {
+		log.Fatalf("failed to subscribe hub to broker: %v", err)
+	}
+	go h.presenceHeartbeat()
+
+	for {
+		select {
+		case client := <-h.register:
+			h.clients[client] = true
+			conns, ok := h.users[client.claims.ID]
+			if !ok {
+				conns = make(map[*Client]bool)
+				h.users[client.claims.ID] = conns
+			}
+			conns[client] = true
+			wsConnectedClients.Add(1)
+			h.setPresence(client, PresenceOnline)
+			log.Printf("Client connected: %s\n", client.claims.ID)
+		case client := <-h.unregister:
+			if h.remove(client) {
+				log.Printf("Client disconnected: %s\n", client.claims.ID)
+			}
+		case sub := <-h.subscribe:
+			if _, ok := h.clients[sub.client]; !ok {
+				continue
+			}
+			room, ok := h.rooms[sub.chatID]
+			if !ok {
+				room = make(map[*Client]bool)
+				h.rooms[sub.chatID] = room
+			}
+			room[sub.client] = true
+			sub.client.rooms[sub.chatID] = true
+		case sub := <-h.unsubscribe:
+			h.leave(sub.client, sub.chatID)
+		case client := <-h.pause:
+			if _, ok := h.clients[client]; ok {
+				client.paused = true
+			}
+		case r := <-h.resume:
+			if _, ok := h.clients[r.client]; !ok {
+				continue
+			}
+			r.client.paused = false
+			held := r.client.held
+			r.client.held = nil
+			if r.frame != nil {
+				h.push(r.client, r.frame)
+			}
+			for _, data := range held {
+				h.push(r.client, data)
+			}
+		case d := <-h.deliver:
+			if len(d.closeSessions) > 0 {
+				h.disconnect(d.closeSessions)
+				continue
+			}
+			if d.client != nil {
+				if _, ok := h.clients[d.client]; ok {
+					h.push(d.client, d.data)
+				}
+				continue
+			}
+			if d.chatID == "" {
+				for userID := range d.members {
+					for client := range h.users[userID] {
+						h.push(client, d.data)
+					}
+				}
+				continue
+			}
+			for client := range h.rooms[d.chatID] {
+				if d.members[client.claims.ID] {
+					h.push(client, d.data)
+				}
+			}
+		}
+	}
+}
+
+// push queues a frame for the client. A client whose buffer is full is
+// evicted rather than allowed to stall the hub. Frames for a paused client
+// are held until its replay arrives, up to half a send buffer.
+func (h *Hub) push(client *Client, data []byte) {
+	if client.paused {
+		if len(client.held) < WSSendBuffer/2 {
+			client.held = append(client.held, data)
+			return
+		}
+	} else {
+		select {
+		case client.send <- data:
+			return
+		default:
+		}
+	}
+
+	client.evicted.Store(true)
+	if h.remove(client) {
+		wsDroppedClients.Add(1)
+		log.Printf("Client evicted as slow consumer: %s\n", client.claims.ID)
+	}
+}
+
+// remove forgets the client and closes its send channel. It's the only
+// place send is closed, and reports false if the client was already gone.
+func (h *Hub) remove(client *Client) bool {
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+	for chatID := range client.rooms {
+		h.leave(client, chatID)
+	}
+	if conns, ok := h.users[client.claims.ID]; ok {
+		delete(conns, client)
+		if len(conns) == 0 {
+			delete(h.users, client.claims.ID)
+		}
+	}
+	delete(h.clients, client)
+	close(client.send)
+	h.dropPresence(client)
+	wsConnectedClients.Add(-1)
+	return true
+}
+
+func (h *Hub) leave(client *Client, chatID string) {
+	room, ok := h.rooms[chatID]
+	if !ok {
+		return
+	}
+	delete(room, client)
+	delete(client.rooms, chatID)
+	if len(room) == 0 {
+		delete(h.rooms, chatID)
+	}
+}
+
+// sendTo queues a frame for a single client.
+func (h *Hub) sendTo(client *Client, data []byte) {
+	h.deliver <- delivery{client: client, data: data}
+}
+
+// sendToChat queues a frame for every client subscribed to the chat whose
+// user is still listed in chat.Users.
+func (h *Hub) sendToChat(chat *services.Chat, data []byte) {
+	h.fanout(delivery{chatID: chat.ID, members: chatMembers(chat), data: data})
+}
+
+// sendToThread queues a frame for every client subscribed to one thread of
+// the chat whose user is still listed in chat.Users.
+func (h *Hub) sendToThread(chat *services.Chat, parentID string, data []byte) {
+	h.fanout(delivery{chatID: threadRoom(chat.ID, parentID), members: chatMembers(chat), data: data})
+}
+
+// threadRoom names the room of a thread. Chat and message IDs never hold
+// a slash, so it can't collide with a chat's own room.
+func threadRoom(chatID, parentID string) string {
+	return chatID + "/" + parentID
+}
+
+// sendToMembers queues a frame for every open connection of every user in
+// chat.Users, whether or not they're subscribed to the chat's room.
+func (h *Hub) sendToMembers(chat *services.Chat, data []byte) {
+	h.fanout(delivery{members: chatMembers(chat), data: data})
+}
+
+// sendToUser queues a frame for every open connection of one user.
+func (h *Hub) sendToUser(userID string, data []byte) {
+	h.fanout(delivery{members: map[string]bool{userID: true}, data: data})
+}
+
+// fanout publishes a room or member delivery through the broker so every
+// instance delivers it to the clients it holds. If the broker is down the
+// frame still reaches this instance's clients.
+func (h *Hub) fanout(d delivery) {
+	wire := wireDelivery{ChatID: d.chatID, Data: d.data, CloseSessions: d.closeSessions}
+	for id := range d.members {
+		wire.Members = append(wire.Members, id)
+	}
+
+	data, err := json.Marshal(wire)
+	if err == nil {
+		err = h.broker.Publish(data)
+	}
+	if err != nil {
+		log.Printf("Broker publish failed, delivering locally: %v\n", err)
+		h.deliver <- d
+	}
+}
+
+// receive hands a delivery published by any instance to the local hub.
+func (h *Hub) receive(data []byte) {
+	var wire wireDelivery
+	if err := json.Unmarshal(data, &wire); err != nil {
+		log.Printf("Dropping malformed broker frame: %v\n", err)
+		return
+	}
+	if wire.Presence != nil {
+		h.applyPresence(*wire.Presence)
+		return
+	}
+	if wire.Search != nil {
+		applySearch(*wire.Search)
+		return
+	}
+
+	members := make(map[string]bool, len(wire.Members))
+	for _, id := range wire.Members {
+		members[id] = true
+	}
+	h.deliver <- delivery{
+		chatID:        wire.ChatID,
+		members:       members,
+		data:          wire.Data,
+		closeSessions: wire.CloseSessions,
+	}
+}
+
+// closeSessions disconnects, on every instance, the sockets opened with an
+// access token from any of the given sessions.
+func (h *Hub) closeSessions(sessionIDs ...string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+	h.fanout(delivery{closeSessions: sessionIDs})
+}
+
+func (h *Hub) disconnect(sessionIDs []string) {
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		revoked[id] = true
+	}
+	for client := range h.clients {
+		if client.claims.SessionID != "" && revoked[client.claims.SessionID] {
+			// closing writes a frame, keep that off the hub goroutine
+			go client.closeWith(websocket.ClosePolicyViolation, "session revoked")
+		}
+	}
+}
+
+// publishMessage pushes a message.* event carrying the full message to the
+// participants of its chat.
+func (h *Hub) publishMessage(event string, msg services.Message) {
+	chat, err := services.GetChatById(h.db, "chats", msg.ChatID)
+	if err != nil {
+		log.Printf("Failed to publish %s for %s: %v\n", event, msg.ID, err)
+		return
+	}
+	summarizeReactions(&msg, "")
+	h.sendToMembers(chat, newEvent(event, msg.ChatID, "", msg))
+}
+
+func chatMembers(chat *services.Chat) map[string]bool {
+	members := make(map[string]bool, len(chat.Users))
+	for _, id := range chat.Users {
+		members[id] = true
+	}
+	return members
+}
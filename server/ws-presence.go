@@ -0,0 +1,371 @@
+package server
+
+import (
+	"encoding/json"
+	"fluffy-coto-tribble/server/services"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+type Presence struct {
+	UserID   string `json:"userId"`
+	Status   string `json:"status"`
+	LastSeen int64  `json:"lastSeen,omitempty"`
+}
+
+// presenceReport is how instances tell each other about the users they
+// hold connections for. A change carries one user's status on the sending
+// instance, offline once their last device there is gone. A snapshot
+// carries every user the instance holds and replaces what was known of it;
+// instances send one every WSPresenceHeartbeat, and when asked to with hello.
+type presenceReport struct {
+	Instance string            `json:"instance"`
+	Seq      uint64            `json:"seq"`
+	Users    map[string]string `json:"users,omitempty"`
+	Snapshot bool              `json:"snapshot,omitempty"`
+	Hello    bool              `json:"hello,omitempty"`
+}
+
+// presenceChange is a user whose cluster-wide status changed.
+type presenceChange struct {
+	userID        string
+	before, after string
+}
+
+// instanceStatus is a user's status on one instance as of report seq.
+// Offline entries are kept until the instance's next snapshot so a report
+// that arrives late can't bring the user back.
+type instanceStatus struct {
+	status string
+	seq    uint64
+}
+
+// instanceState is when an instance was last heard from, and the seq of
+// its latest snapshot; anything it reported before that is stale.
+type instanceState struct {
+	heard time.Time
+	floor uint64
+}
+
+// presenceTracker keeps the status of every open connection per user on
+// this instance, and what every instance, this one included, last reported
+// through the hub's broker. A user is online if any device anywhere is
+// online, away if every device is away and offline once the last one
+// disconnects. An instance that stops sending snapshots is forgotten
+// after three missed heartbeats.
+type presenceTracker struct {
+	mu        sync.RWMutex
+	instance  string
+	seq       uint64
+	devices   map[string]map[*Client]string        // user ID -> local connections
+	reported  map[string]map[string]instanceStatus // user ID -> instance -> status
+	instances map[string]*instanceState
+}
+
+func newPresenceTracker(instance string) *presenceTracker {
+	return &presenceTracker{
+		instance:  instance,
+		devices:   make(map[string]map[*Client]string),
+		reported:  make(map[string]map[string]instanceStatus),
+		instances: make(map[string]*instanceState),
+	}
+}
+
+// set records the status of one local connection and returns the report
+// to publish if the user's status on this instance changed.
+func (p *presenceTracker) set(client *Client, status string) *presenceReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	userID := client.claims.ID
+	before := p.localStatusLocked(userID)
+	devices, ok := p.devices[userID]
+	if !ok {
+		devices = make(map[*Client]string)
+		p.devices[userID] = devices
+	}
+	devices[client] = status
+	return p.changeLocked(userID, before)
+}
+
+// drop forgets a local connection and returns the report to publish if the
+// user's status on this instance changed.
+func (p *presenceTracker) drop(client *Client) *presenceReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	userID := client.claims.ID
+	before := p.localStatusLocked(userID)
+	if devices, ok := p.devices[userID]; ok {
+		delete(devices, client)
+		if len(devices) == 0 {
+			delete(p.devices, userID)
+		}
+	}
+	return p.changeLocked(userID, before)
+}
+
+func (p *presenceTracker) changeLocked(userID, before string) *presenceReport {
+	after := p.localStatusLocked(userID)
+	if before == after {
+		return nil
+	}
+	p.seq++
+	return &presenceReport{Instance: p.instance, Seq: p.seq, Users: map[string]string{userID: after}}
+}
+
+// snapshot reports every user with a connection to this instance.
+func (p *presenceTracker) snapshot(hello bool) presenceReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+	users := make(map[string]string, len(p.devices))
+	for userID := range p.devices {
+		users[userID] = p.localStatusLocked(userID)
+	}
+	return presenceReport{Instance: p.instance, Seq: p.seq, Users: users, Snapshot: true, Hello: hello}
+}
+
+// apply takes in a report from any instance and returns the users whose
+// cluster-wide status it changed.
+func (p *presenceTracker) apply(r presenceReport) []presenceChange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	inst, ok := p.instances[r.Instance]
+	if !ok {
+		inst = &instanceState{}
+		p.instances[r.Instance] = inst
+	}
+	inst.heard = time.Now()
+	if r.Seq < inst.floor {
+		return nil
+	}
+
+	users := r.Users
+	if r.Snapshot {
+		inst.floor = r.Seq
+		// users the instance no longer mentions have left it
+		users = make(map[string]string, len(r.Users))
+		for userID, byInstance := range p.reported {
+			if _, ok := byInstance[r.Instance]; ok {
+				users[userID] = PresenceOffline
+			}
+		}
+		for userID, status := range r.Users {
+			users[userID] = status
+		}
+	}
+
+	var changes []presenceChange
+	for userID, status := range users {
+		before := p.statusLocked(userID)
+		byInstance := p.reported[userID]
+		if cur, ok := byInstance[r.Instance]; ok && cur.seq > r.Seq {
+			continue // a later report already arrived
+		}
+		switch {
+		case status == PresenceOffline && r.Snapshot:
+			// the new floor keeps older reports out without the entry
+			delete(byInstance, r.Instance)
+			if len(byInstance) == 0 {
+				delete(p.reported, userID)
+			}
+		default:
+			if byInstance == nil {
+				byInstance = make(map[string]instanceStatus)
+				p.reported[userID] = byInstance
+			}
+			byInstance[r.Instance] = instanceStatus{status: status, seq: r.Seq}
+		}
+		if after := p.statusLocked(userID); after != before {
+			changes = append(changes, presenceChange{userID: userID, before: before, after: after})
+		}
+	}
+	return changes
+}
+
+// expire forgets instances not heard from since cutoff and returns the
+// users whose status that changed. leader reports whether this instance
+// has the lowest ID of those still alive, making it the one to record
+// lastSeen for users who went offline with a dead instance.
+func (p *presenceTracker) expire(cutoff time.Time) (changes []presenceChange, leader bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var alive []string
+	for instance, state := range p.instances {
+		if instance != p.instance && state.heard.Before(cutoff) {
+			delete(p.instances, instance)
+			continue
+		}
+		alive = append(alive, instance)
+	}
+	leader = !slices.ContainsFunc(alive, func(id string) bool { return id < p.instance })
+
+	for userID, byInstance := range p.reported {
+		before := p.statusLocked(userID)
+		for instance := range byInstance {
+			if _, ok := p.instances[instance]; !ok {
+				delete(byInstance, instance)
+			}
+		}
+		if len(byInstance) == 0 {
+			delete(p.reported, userID)
+		}
+		if after := p.statusLocked(userID); after != before {
+			changes = append(changes, presenceChange{userID: userID, before: before, after: after})
+		}
+	}
+	return changes, leader
+}
+
+// status is the user's status across every instance.
+func (p *presenceTracker) status(userID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.statusLocked(userID)
+}
+
+// hasLocal reports whether any user holds a connection to this instance.
+func (p *presenceTracker) hasLocal() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.devices) > 0
+}
+
+func (p *presenceTracker) statusLocked(userID string) string {
+	status := PresenceOffline
+	for _, s := range p.reported[userID] {
+		switch s.status {
+		case PresenceOnline:
+			return PresenceOnline
+		case PresenceAway:
+			status = PresenceAway
+		}
+	}
+	return status
+}
+
+func (p *presenceTracker) localStatusLocked(userID string) string {
+	devices := p.devices[userID]
+	if len(devices) == 0 {
+		return PresenceOffline
+	}
+	for _, s := range devices {
+		if s == PresenceOnline {
+			return PresenceOnline
+		}
+	}
+	return PresenceAway
+}
+
+// setPresence updates one connection's status and, if that changes the
+// user's status on this instance, reports it to every instance. Safe to
+// call from any goroutine.
+func (h *Hub) setPresence(client *Client, status string) {
+	if r := h.presence.set(client, status); r != nil {
+		go h.publishPresence(*r)
+	}
+}
+
+// dropPresence is setPresence for a connection that has gone away.
+func (h *Hub) dropPresence(client *Client) {
+	if r := h.presence.drop(client); r != nil {
+		go h.publishPresence(*r)
+	}
+}
+
+// publishPresence sends a report through the broker. If the broker is down
+// it's applied here alone, like a fan-out delivery.
+func (h *Hub) publishPresence(r presenceReport) {
+	data, err := json.Marshal(wireDelivery{Presence: &r})
+	if err == nil {
+		err = h.broker.Publish(data)
+	}
+	if err != nil {
+		log.Printf("Broker publish failed, applying presence locally: %v\n", err)
+		h.applyPresence(r)
+	}
+}
+
+// applyPresence takes in a report from any instance. Every instance
+// announces the changes it causes to the clients it holds; only the
+// instance the user left writes their lastSeen.
+func (h *Hub) applyPresence(r presenceReport) {
+	if r.Hello && r.Instance != h.instance {
+		go h.publishPresence(h.presence.snapshot(false))
+	}
+	for _, change := range h.presence.apply(r) {
+		go h.announcePresence(change, r.Instance == h.instance)
+	}
+}
+
+// presenceHeartbeat sends this instance's snapshot every WSPresenceHeartbeat
+// and forgets instances that stopped sending theirs. The first snapshot
+// says hello, so a new instance learns everyone else's users straight away.
+func (h *Hub) presenceHeartbeat() {
+	h.publishPresence(h.presence.snapshot(true))
+
+	ticker := time.NewTicker(WSPresenceHeartbeat)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.publishPresence(h.presence.snapshot(false))
+
+		changes, leader := h.presence.expire(time.Now().Add(-3 * WSPresenceHeartbeat))
+		for _, change := range changes {
+			go h.announcePresence(change, leader)
+		}
+	}
+}
+
+// announcePresence sends presence.updated to the clients on this instance
+// that share a chat with the user, and records lastSeen if the user went
+// offline and recordLastSeen is set. It does database work, so never call
+// it on the hub goroutine.
+func (h *Hub) announcePresence(change presenceChange, recordLastSeen bool) {
+	p := Presence{UserID: change.userID, Status: change.after}
+	if p.Status == PresenceOffline {
+		p.LastSeen = time.Now().Unix()
+		if recordLastSeen {
+			if err := services.UpdateLastSeen(h.db, "users", p.UserID, p.LastSeen); err != nil {
+				log.Printf("Failed to record lastSeen for %s: %v\n", p.UserID, err)
+			}
+		}
+	}
+	if !h.presence.hasLocal() {
+		return
+	}
+
+	ids, err := services.GetUserChatIDs(h.db, "chat_members", p.UserID)
+	if err != nil {
+		log.Printf("Failed to announce presence for %s: %v\n", p.UserID, err)
+		return
+	}
+	chats, err := services.GetChatsByIds(h.db, "chats", ids)
+	if err != nil {
+		log.Printf("Failed to announce presence for %s: %v\n", p.UserID, err)
+		return
+	}
+
+	members := map[string]bool{}
+	for _, chat := range chats {
+		for _, id := range chat.Users {
+			members[id] = true
+		}
+	}
+	if len(members) == 0 {
+		return
+	}
+
+	h.deliver <- delivery{members: members, data: newEvent(EventPresenceUpdated, "", "", p)}
+}
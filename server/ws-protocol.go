@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// WSProtocolVersion is the envelope version this server speaks. Frames
+// carrying any other "v" are rejected with an unsupported_version error.
+const WSProtocolVersion = 1
+
+// Event types. Client requests are verbs, server events are past tense.
+const (
+	EventAck   = "ack"
+	EventError = "error"
+
+	EventSubscribe   = "subscribe"
+	EventUnsubscribe = "unsubscribe"
+
+	EventMessageCreate  = "message.create"
+	EventMessageUpdate  = "message.update"
+	EventMessageEdit    = "message.edit" // alias of message.update
+	EventMessageDelete  = "message.delete"
+	EventMessageForward = "message.forward"
+	EventMessageCreated = "message.created"
+	EventMessageUpdated = "message.updated"
+	EventMessageDeleted = "message.deleted"
+	EventMessageHidden  = "message.hidden"
+
+	EventMention = "mention"
+
+	EventScheduledSent   = "scheduled.sent"
+	EventScheduledFailed = "scheduled.failed"
+
+	EventThreadReplied = "thread.replied"
+	EventThreadUpdated = "thread.updated"
+
+	EventReactionAdd     = "reaction.add"
+	EventReactionRemove  = "reaction.remove"
+	EventReactionAdded   = "reaction.added"
+	EventReactionRemoved = "reaction.removed"
+
+	EventPresenceSet     = "presence.set"
+	EventPresenceUpdated = "presence.updated"
+
+	EventTypingStart   = "typing.start"
+	EventTypingStop    = "typing.stop"
+	EventTypingStarted = "typing.started"
+	EventTypingStopped = "typing.stopped"
+
+	EventReadMark   = "read.mark"
+	EventReadMarked = "read.marked"
+
+	EventResume       = "resume"
+	EventResumeReplay = "resume.replay"
+)
+
+// Error codes carried in error frames.
+const (
+	ErrCodeBadRequest         = "bad_request"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeConflict           = "conflict"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeInternal           = "internal"
+)
+
+// WSEnvelope wraps every frame in both directions. ID is an optional
+// client-chosen correlation ID that the server echoes on the ack or error
+// frame answering that request.
+type WSEnvelope struct {
+	Version   int             `json:"v"`
+	Type      string          `json:"type"`
+	ID        string          `json:"id,omitempty"`
+	ChatID    string          `json:"chatId,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Timestamp int64           `json:"timestamp"`
+}
+
+// WSError is the payload of an error frame, and doubles as the error
+// returned by event handlers.
+type WSError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *WSError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func wsErr(code, message string) *WSError {
+	return &WSError{Code: code, Message: message}
+}
+
+// WSAck is the payload of an ack frame.
+type WSAck struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// newEvent encodes a server frame of the given type.
+func newEvent(eventType, chatID, id string, payload interface{}) []byte {
+	var raw json.RawMessage
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("Failed to marshal %s payload: %v\n", eventType, err)
+		}
+		raw = b
+	}
+	outBytes, _ := json.Marshal(WSEnvelope{
+		Version:   WSProtocolVersion,
+		Type:      eventType,
+		ID:        id,
+		ChatID:    chatID,
+		Payload:   raw,
+		Timestamp: time.Now().UnixMilli(),
+	})
+	return outBytes
+}
+
+// decodePayload unmarshals an envelope payload into v, reporting a
+// bad_request error when it doesn't fit.
+func decodePayload(env WSEnvelope, v interface{}) error {
+	if len(env.Payload) == 0 {
+		return wsErr(ErrCodeBadRequest, "payload is required")
+	}
+	if err := json.Unmarshal(env.Payload, v); err != nil {
+		return wsErr(ErrCodeBadRequest, "invalid payload: "+err.Error())
+	}
+	return nil
+}
+
+// asWSError maps any handler error onto an error frame payload.
+func asWSError(err error) *WSError {
+	var target *WSError
+	if errors.As(err, &target) {
+		return target
+	}
+	return &WSError{Code: ErrCodeInternal, Message: err.Error()}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fluffy-coto-tribble/server/authentication"
+	"fluffy-coto-tribble/server/services"
+	"log"
+	"sort"
+)
+
+// ResumeCursor is the newest message a client has seen in one chat.
+type ResumeCursor struct {
+	Timestamp int64  `json:"timestamp"`
+	MessageID string `json:"messageId,omitempty"`
+}
+
+type resumePayload struct {
+	Cursors map[string]ResumeCursor `json:"cursors"` // chat ID -> cursor
+}
+
+// ResumeReplay is the payload of a resume.replay frame: the messages a
+// client missed in each chat it sent a cursor for.
+type ResumeReplay struct {
+	Chats []ChatReplay `json:"chats"`
+}
+
+// ChatReplay holds the missed messages of one chat, oldest first. HasMore
+// means the client missed more than WSReplayLimit and must resync the chat
+// by paging the rest over REST.
+type ChatReplay struct {
+	ChatID   string             `json:"chatId"`
+	Messages []services.Message `json:"messages"`
+	HasMore  bool               `json:"hasMore"`
+}
+
+// replay is the frame the hub sends a paused client ahead of anything that
+// was held for it while paused. A nil frame just unpauses the client.
+type replay struct {
+	client *Client
+	frame  []byte
+}
+
+// handleResume replays the messages a reconnecting client missed in each
+// chat it sends a cursor for, in a single resume.replay frame. Live
+// deliveries are held back until the replay is queued, so the client sees
+// history then live traffic in order.
+func handleResume(c *Client, hub *Hub, env WSEnvelope) error {
+	var body resumePayload
+	if err := decodePayload(env, &body); err != nil {
+		return err
+	}
+
+	hub.pause <- c
+
+	var out ResumeReplay
+	replayed := 0
+	for chatID, cursor := range body.Cursors {
+		if _, err := c.chatFor(hub, chatID); err != nil {
+			hub.resume <- replay{client: c}
+			return err
+		}
+
+		missed, err := missedMessages(hub, c.claims, chatID, cursor)
+		if err != nil {
+			log.Printf("Failed to replay %s for %s: %v\n", chatID, c.claims.ID, err)
+			hub.resume <- replay{client: c}
+			return err
+		}
+
+		out.Chats = append(out.Chats, missed)
+		replayed += len(missed.Messages)
+	}
+
+	hub.resume <- replay{client: c, frame: newEvent(EventResumeReplay, "", "", out)}
+	c.ack(hub, env, map[string]int{"replayed": replayed})
+	return nil
+}
+
+// missedMessages loads the top-level messages in a chat newer than the
+// cursor as the user may see them, oldest first, capped at WSReplayLimit.
+// Replies reach thread subscribers as thread.replied and aren't replayed.
+func missedMessages(hub *Hub, claims *authentication.UserClaims, chatID string, cursor ResumeCursor) (ChatReplay, error) {
+	msgs, more, err := services.GetChatMessagesSince(hub.db, "messages", chatID, cursor.Timestamp, int32(WSReplayLimit))
+	if err != nil {
+		return ChatReplay{}, err
+	}
+
+	sort.Slice(msgs, func(i, j int) bool {
+		if msgs[i].Timestamp != msgs[j].Timestamp {
+			return msgs[i].Timestamp < msgs[j].Timestamp
+		}
+		return msgs[i].ID < msgs[j].ID
+	})
+
+	// timestamps are per second, so messages sharing the cursor's second
+	// come back too; IDs sort by creation time, so those up to the cursor
+	// message were already seen
+	missed := make([]services.Message, 0, len(msgs))
+	for _, m := range messagesView(msgs, claims) {
+		if m.Timestamp == cursor.Timestamp && cursor.MessageID != "" && m.ID <= cursor.MessageID {
+			continue
+		}
+		missed = append(missed, m)
+	}
+
+	return ChatReplay{ChatID: chatID, Messages: missed, HasMore: more}, nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Typing is the payload of typing.started and typing.stopped.
+type Typing struct {
+	UserID string `json:"userId"`
+	ChatID string `json:"chatId"`
+}
+
+// typingTracker remembers who is typing where so a typing.stopped can be
+// sent on the client's behalf if it goes quiet without sending typing.stop.
+type typingTracker struct {
+	mu     sync.Mutex
+	timers map[Typing]*time.Timer
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{timers: make(map[Typing]*time.Timer)}
+}
+
+// start (re)arms the expiry for t and reports whether t was already typing.
+func (tt *typingTracker) start(t Typing, expire func()) bool {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	old, ok := tt.timers[t]
+	if ok {
+		old.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(WSTypingTimeout, func() {
+		if tt.expire(t, &timer) {
+			expire()
+		}
+	})
+	tt.timers[t] = timer
+	return ok
+}
+
+// stop clears t and reports whether it was typing.
+func (tt *typingTracker) stop(t Typing) bool {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	timer, ok := tt.timers[t]
+	if !ok {
+		return false
+	}
+	timer.Stop()
+	delete(tt.timers, t)
+	return true
+}
+
+// expire clears t if timer is still the one armed for it, so a timer that
+// fires while being replaced can't cut short a fresh typing.start.
+func (tt *typingTracker) expire(t Typing, timer **time.Timer) bool {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	if tt.timers[t] != *timer {
+		return false
+	}
+	delete(tt.timers, t)
+	return true
+}
+
+// signalLimiter is a token bucket guarding ephemeral signals from one
+// client. It's only touched from that client's read goroutine.
+type signalLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *signalLimiter) allow() bool {
+	now := time.Now()
+	if l.last.IsZero() {
+		l.tokens = float64(WSSignalBurst)
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * WSSignalRate
+		if l.tokens > float64(WSSignalBurst) {
+			l.tokens = float64(WSSignalBurst)
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// sendSignal delivers an ephemeral frame to the other members subscribed to
+// a chat room; the sending user's own connections are skipped.
+func (h *Hub) sendSignal(chatID string, members map[string]bool, from string, data []byte) {
+	others := make(map[string]bool, len(members))
+	for id := range members {
+		if id != from {
+			others[id] = true
+		}
+	}
+	h.fanout(delivery{chatID: chatID, members: others, data: data})
+}
+
+func handleTypingStart(c *Client, hub *Hub, env WSEnvelope) error {
+	if !c.signals.allow() {
+		return wsErr(ErrCodeRateLimited, "too many signals, slow down")
+	}
+	chat, err := c.chatFor(hub, env.ChatID)
+	if err != nil {
+		return err
+	}
+
+	t := Typing{UserID: c.claims.ID, ChatID: chat.ID}
+	members := chatMembers(chat)
+	expire := func() {
+		hub.sendSignal(t.ChatID, members, t.UserID, newEvent(EventTypingStopped, t.ChatID, "", t))
+	}
+	if !hub.typing.start(t, expire) {
+		hub.sendSignal(t.ChatID, members, t.UserID, newEvent(EventTypingStarted, t.ChatID, "", t))
+	}
+
+	c.ack(hub, env, nil)
+	return nil
+}
+
+func handleTypingStop(c *Client, hub *Hub, env WSEnvelope) error {
+	if !c.signals.allow() {
+		return wsErr(ErrCodeRateLimited, "too many signals, slow down")
+	}
+	chat, err := c.chatFor(hub, env.ChatID)
+	if err != nil {
+		return err
+	}
+
+	t := Typing{UserID: c.claims.ID, ChatID: chat.ID}
+	if hub.typing.stop(t) {
+		hub.sendSignal(t.ChatID, chatMembers(chat), t.UserID, newEvent(EventTypingStopped, t.ChatID, "", t))
+	}
+
+	c.ack(hub, env, nil)
+	return nil
+}